@@ -0,0 +1,3 @@
+module github.com/buzztroll/mycloud
+
+go 1.16
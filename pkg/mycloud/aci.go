@@ -0,0 +1,50 @@
+package mycloud
+
+import (
+	"context"
+	"errors"
+	"os"
+	"regexp"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////
+// Azure Container Instances
+/////////////////////////////////////////////////////////
+type ACICloud struct {
+	BaseCloud
+}
+
+// Container groups run on Service Fabric without the instance metadata
+// service.  What is known about them is in the Fabric_ variables, and the
+// application of an ACI container group is named caas-<id>.
+func NewACICloud() ACICloud {
+	c := ACICloud{}
+	c.fingerprint = fingerprintFor("aci")
+	c.name = c.fingerprint.Name
+	c.supportsKey = true
+	return c
+}
+
+func (c *ACICloud) detectEffectiveCloud(ctx context.Context) {
+	app := os.Getenv("Fabric_ApplicationName")
+	matched, err := regexp.MatchString(c.fingerprint.IdPattern, app)
+	c.isMyCloud = app != "" && err == nil && matched
+	c.signal = signalEnvironment
+	if c.isMyCloud {
+		c.setAttribute("environment", "container")
+		c.setAttribute("aci.container", os.Getenv("Fabric_CodePackageName"))
+	}
+}
+
+// Keys are the Fabric_ variables, with or without the prefix.
+func (c *ACICloud) getKey(ctx context.Context, key string) (*string, error) {
+	if !strings.HasPrefix(key, "Fabric_") {
+		key = "Fabric_" + key
+	}
+	val, ok := os.LookupEnv(key)
+	if !ok {
+		return nil, errors.New("No such key " + key)
+	}
+	return &val, nil
+}
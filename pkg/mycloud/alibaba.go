@@ -0,0 +1,49 @@
+package mycloud
+
+import (
+	"context"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Alibaba Cloud
+/////////////////////////////////////////////////////////
+type AlibabaCloud struct {
+	SimpleUrlBasedCloud
+}
+
+func NewAlibabaCloud() AlibabaCloud {
+	c := AlibabaCloud{}
+	c.setFingerprint(fingerprintFor("alibaba"))
+	c.supportsKey = true
+	return c
+}
+
+// In hardened mode ECS wants a token much like IMDSv2.  Without one the
+// requests are made in normal mode.
+func (c *AlibabaCloud) detectEffectiveCloud(ctx context.Context) {
+	headers := map[string]string{"X-aliyun-ecs-metadata-token-ttl-seconds": "21600"}
+	token, _, err := fetchUrl(ctx, "PUT", c.fingerprint.Urls["token"], headers)
+	if err == nil {
+		c.headers = provider.MergeStrings(c.headers, map[string]string{"X-aliyun-ecs-metadata-token": *token})
+	}
+	c.SimpleUrlBasedCloud.detectEffectiveCloud(ctx)
+}
+
+func (c *AlibabaCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "instance-id", nil},
+		{"instance_type", "instance/instance-type", nil},
+		{"region", "region-id", nil},
+		{"zone", "zone-id", nil},
+		{"private_ip", "private-ipv4", nil},
+		{"public_ip", "eipv4", nil},
+		{"hostname", "hostname", nil},
+		{"account_id", "owner-account-id", nil},
+	}
+}
+
+func (c *AlibabaCloud) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
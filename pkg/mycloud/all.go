@@ -0,0 +1,94 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+const (
+	verdictMatched    = "matched"
+	verdictNotMatched = "not matched"
+	verdictError      = "error"
+)
+
+// What one detector concluded, for -all.
+type Verdict struct {
+	Cloud         string `json:"cloud"`
+	Id            string `json:"id"`
+	Verdict       string `json:"verdict"`
+	Confidence    int    `json:"confidence,omitempty"`
+	Selected      bool   `json:"selected"`
+	DurationMs    int64  `json:"duration_ms"`
+	Error         string `json:"error,omitempty"`
+	ErrorCategory string `json:"error_category,omitempty"`
+	Diagnostic    string `json:"diagnostic,omitempty"`
+}
+
+// Something that answered but is not the cloud is a miss, while a probe
+// that could not get an answer at all could not tell.
+func verdictOf(cd CloudDetector) string {
+	if cd.isEffectiveCloud() {
+		return verdictMatched
+	}
+	switch classifyError(cd.cloudProbeError()) {
+	case "", ErrorCategoryHTTPStatus, ErrorCategoryInvalid:
+		return verdictNotMatched
+	}
+	return verdictError
+}
+
+// Print the verdict of every detector, for debugging environments where
+// the wrong cloud, or none, is reported.  The exit code is the one the
+// detection alone would have.
+func runAll(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	selected, detectionErr := detectCloud(ctx, cdList, status)
+	probes := status.Probes[len(status.Probes)-len(cdList):]
+	verdicts := make([]Verdict, len(cdList))
+	for i, cd := range cdList {
+		verdicts[i] = Verdict{
+			Cloud:         cd.cloudDescription(),
+			Id:            cd.cloudFingerprint().ID,
+			Verdict:       verdictOf(cd),
+			Confidence:    cd.cloudConfidence(),
+			Selected:      cd == selected,
+			DurationMs:    probes[i].DurationMs,
+			Error:         probes[i].Error,
+			ErrorCategory: probes[i].ErrorCategory,
+			Diagnostic:    cd.cloudDiagnostic()}
+	}
+
+	if globalOpts.format == "json" {
+		out, err := json.MarshalIndent(verdicts, "", "  ")
+		if err != nil {
+			status.Error = err.Error()
+			return errorExitCode
+		}
+		fmt.Printf("%s\n", out)
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "CLOUD\tVERDICT\tCONFIDENCE\tMS\tDETAIL\n")
+		for _, v := range verdicts {
+			name := v.Cloud
+			if v.Selected {
+				name = "*" + name
+			}
+			detail := v.Diagnostic
+			if detail == "" && v.Error != "" {
+				detail = v.ErrorCategory + ": " + v.Error
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", name, v.Verdict, v.Confidence, v.DurationMs, detail)
+		}
+		w.Flush()
+	}
+
+	if detectionErr != nil {
+		return detectionErr.ExitCode
+	}
+	if _, ok := selected.(*BareMetalDetector); ok {
+		return bareMetalExitCode
+	}
+	return foundExitCode
+}
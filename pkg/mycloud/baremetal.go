@@ -0,0 +1,48 @@
+package mycloud
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Bare metal
+/////////////////////////////////////////////////////////
+type BareMetalDetector struct {
+	BaseCloud
+}
+
+// Checked after every cloud, since bare metal clouds such as Equinix Metal
+// have real vendors in their DMI data too.
+func NewBareMetalDetector() BareMetalDetector {
+	c := BareMetalDetector{}
+	c.fingerprint = fingerprintFor("baremetal")
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceLow
+	return c
+}
+
+func (c *BareMetalDetector) detectEffectiveCloud(ctx context.Context) {
+	c.isMyCloud = false
+	dmi := readDMI()
+	if len(dmi) == 0 || dmiHypervisor(dmi) != "" || cpuHypervisorFlag() {
+		return
+	}
+	c.isMyCloud = true
+	c.signal = signalDMI
+	machine := strings.TrimSpace(dmi["sys_vendor"] + " " + dmi["product_name"])
+	if machine != "" {
+		c.name = fmt.Sprintf("%s (%s)", c.fingerprint.Name, machine)
+	}
+	c.setAttribute("baremetal.vendor", dmi["sys_vendor"])
+	c.setAttribute("baremetal.product", dmi["product_name"])
+}
+
+// The kernel sets the hypervisor flag when CPUID says it runs in a VM,
+// which catches the hypervisors that leave DMI alone.
+func cpuHypervisorFlag() bool {
+	return cpuFlags()["hypervisor"]
+}
@@ -0,0 +1,24 @@
+//go:build linux
+
+package mycloud
+
+import (
+	"net"
+	"syscall"
+)
+
+// Bind the socket to the device so the request leaves through it no matter
+// what the routing table says.
+func bindDialer(dialer *net.Dialer, iface string) error {
+	dialer.Control = func(network, address string, c syscall.RawConn) error {
+		var bindErr error
+		err := c.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		return bindErr
+	}
+	return nil
+}
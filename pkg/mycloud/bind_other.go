@@ -0,0 +1,28 @@
+//go:build !linux
+
+package mycloud
+
+import (
+	"errors"
+	"net"
+)
+
+// Without SO_BINDTODEVICE the best we can do is to use the interface's
+// IPv4 address as the source of the connection.
+func bindDialer(dialer *net.Dialer, iface string) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return err
+	}
+	for _, addr := range addrs {
+		if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+			dialer.LocalAddr = &net.TCPAddr{IP: ipnet.IP}
+			return nil
+		}
+	}
+	return errors.New("No IPv4 address on interface " + iface)
+}
@@ -0,0 +1,62 @@
+package mycloud
+
+import (
+	"sync"
+	"time"
+)
+
+// Remembers, across detections, the clouds whose probes keep failing, so
+// that a long-running program detecting again and again does not send
+// their link-local requests every time.  After failures probes in a row
+// without a match a cloud is not probed again until cooldown has passed,
+// and then once, to see whether it has come up.  A match closes it again.
+type ProbeBreaker struct {
+	failures int
+	cooldown time.Duration
+	lock     *sync.Mutex
+	clouds   map[string]*breakerCircuit
+}
+
+type breakerCircuit struct {
+	failures int
+	retryAt  time.Time
+}
+
+func NewProbeBreaker(failures int, cooldown time.Duration) ProbeBreaker {
+	return ProbeBreaker{failures: failures, cooldown: cooldown, lock: &sync.Mutex{}, clouds: map[string]*breakerCircuit{}}
+}
+
+// Whether the cloud may be probed.  Once its cooldown has passed one
+// probe is let through and the cooldown starts over, so that only one of
+// the detections running at the time tries it.
+func (b ProbeBreaker) allow(id string, now time.Time) bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c := b.clouds[id]
+	if b.failures <= 0 || c == nil || c.failures < b.failures {
+		return true
+	}
+	if now.Before(c.retryAt) {
+		return false
+	}
+	c.retryAt = now.Add(b.cooldown)
+	return true
+}
+
+func (b ProbeBreaker) record(id string, matched bool, now time.Time) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if matched {
+		delete(b.clouds, id)
+		return
+	}
+	c := b.clouds[id]
+	if c == nil {
+		c = &breakerCircuit{}
+		b.clouds[id] = c
+	}
+	c.failures++
+	if c.failures >= b.failures {
+		c.retryAt = now.Add(b.cooldown)
+	}
+}
@@ -0,0 +1,48 @@
+package mycloud
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeBreaker(t *testing.T) {
+	b := NewProbeBreaker(2, time.Minute)
+	now := time.Unix(1700000000, 0)
+	steps := []struct {
+		after   time.Duration
+		allowed bool
+		matched bool
+	}{
+		{0, true, false},
+		{0, true, false},
+		// Open after two failures.
+		{time.Second, false, false},
+		// Half open once the cooldown has passed, and only for one probe.
+		{time.Minute + time.Second, true, false},
+		{time.Minute + 2*time.Second, false, false},
+		{2*time.Minute + 2*time.Second, true, true},
+		// Closed again by the match.
+		{2*time.Minute + 3*time.Second, true, false},
+	}
+	for i, step := range steps {
+		at := now.Add(step.after)
+		allowed := b.allow("aws", at)
+		if allowed != step.allowed {
+			t.Fatalf("step %d: allowed is %v", i, allowed)
+		}
+		if allowed {
+			b.record("aws", step.matched, at)
+		}
+	}
+	if !b.allow("gce", now) {
+		t.Error("another cloud is not allowed")
+	}
+
+	off := NewProbeBreaker(0, time.Minute)
+	for i := 0; i < 5; i++ {
+		off.record("aws", false, now)
+	}
+	if !off.allow("aws", now) {
+		t.Error("a breaker with no failures allowed is not off")
+	}
+}
@@ -0,0 +1,265 @@
+package mycloud
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A Cloud that keeps what it fetched for a while, for programs that read
+// the metadata often, e.g. on every request they serve.  Each key is kept
+// for the TTL of the longest CacheKeyTTL prefix it has, CacheTokenTTL's
+// when it is a credential or token, and CacheTTL's otherwise.  With
+// CacheStale a value that has run out is still returned, for that long
+// after, while it is fetched again in the background.  Errors are not
+// kept, and Flush and Invalidate drop what is.  It is safe to use from several goroutines, which
+// share the Summary and Info it returns and must not change them.
+type CachedProvider struct {
+	Cloud
+	config cacheConfig
+	lock   *sync.Mutex
+	// By key, and Summary and Info by the names below, which are not
+	// metadata keys.
+	entries map[string]*cacheEntry
+}
+
+type cacheConfig struct {
+	ttl      time.Duration
+	ttls     map[string]time.Duration
+	tokenTTL time.Duration
+	stale    time.Duration
+}
+
+type cacheEntry struct {
+	value      interface{}
+	fetched    time.Time
+	refreshing bool
+}
+
+const (
+	summaryCacheKey = "\x00summary"
+	infoCacheKey    = "\x00info"
+)
+
+// An option of NewCachedProvider.
+type CacheOption func(*cacheConfig)
+
+// How long values are kept, a minute by default.
+func CacheTTL(ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.ttl = ttl
+	}
+}
+
+// How long the key, or every key under it when it ends in a /, is kept,
+// e.g. less for iam/security-credentials/ or more for instance-id.
+func CacheKeyTTL(key string, ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.ttls[key] = ttl
+	}
+}
+
+// How long the credentials and tokens, such as those under
+// iam/security-credentials/ or service-accounts/default/token, are kept,
+// CacheTTL's by default.  CacheKeyTTL still decides for the keys it names.
+func CacheTokenTTL(ttl time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.tokenTTL = ttl
+	}
+}
+
+// How long a value that has run out may still be returned while it is
+// fetched again, 0 by default.
+func CacheStale(stale time.Duration) CacheOption {
+	return func(c *cacheConfig) {
+		c.stale = stale
+	}
+}
+
+// The clock of the cache, which tests move.
+var cacheNow = time.Now
+
+func NewCachedProvider(cloud Cloud, opts ...CacheOption) CachedProvider {
+	config := cacheConfig{ttl: time.Minute, ttls: map[string]time.Duration{}}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	return CachedProvider{Cloud: cloud, config: config, lock: &sync.Mutex{}, entries: map[string]*cacheEntry{}}
+}
+
+// The keys that are credentials or tokens, which run out sooner than the
+// rest of the metadata.
+var tokenKeyPattern = regexp.MustCompile(`(?i)security-credentials|(^|/)(token|identity)\b`)
+
+func (p CachedProvider) ttlOf(key string) time.Duration {
+	ttl := p.config.ttl
+	if p.config.tokenTTL != 0 && tokenKeyPattern.MatchString(key) {
+		ttl = p.config.tokenTTL
+	}
+	longest := -1
+	for prefix, prefixTTL := range p.config.ttls {
+		matches := key == prefix || (strings.HasSuffix(prefix, "/") && strings.HasPrefix(key, prefix))
+		if matches && len(prefix) > longest {
+			ttl = prefixTTL
+			longest = len(prefix)
+		}
+	}
+	return ttl
+}
+
+// The value of name, from the cache while it is fresh or stale, and from
+// fetch otherwise.  A stale one is fetched again by one goroutine at a
+// time, which the callers do not wait for.
+func (p CachedProvider) cached(ctx context.Context, name string, ttl time.Duration, fetch func(context.Context) (interface{}, error)) (interface{}, error) {
+	now := cacheNow()
+	p.lock.Lock()
+	entry := p.entries[name]
+	if entry != nil {
+		age := now.Sub(entry.fetched)
+		if age < ttl {
+			p.lock.Unlock()
+			return entry.value, nil
+		}
+		if age < ttl+p.config.stale {
+			if !entry.refreshing {
+				entry.refreshing = true
+				// The caller's context may end as soon as it has the
+				// stale value.
+				go p.refresh(context.Background(), name, fetch)
+			}
+			p.lock.Unlock()
+			return entry.value, nil
+		}
+	}
+	p.lock.Unlock()
+
+	value, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.store(name, value)
+	return value, nil
+}
+
+// A value flushed while it was being fetched again stays flushed.
+func (p CachedProvider) refresh(ctx context.Context, name string, fetch func(context.Context) (interface{}, error)) {
+	p.lock.Lock()
+	stale := p.entries[name]
+	p.lock.Unlock()
+	value, err := fetch(ctx)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.entries[name] != stale {
+		return
+	}
+	if err != nil {
+		logOutput("Could not refresh %s, keeping the stale value: %s\n", strings.TrimPrefix(name, "\x00"), err)
+		stale.refreshing = false
+		return
+	}
+	p.entries[name] = &cacheEntry{value: value, fetched: cacheNow()}
+}
+
+func (p CachedProvider) store(name string, value interface{}) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.entries[name] = &cacheEntry{value: value, fetched: cacheNow()}
+}
+
+// Drop everything, e.g. after the metadata was changed, so that it is
+// fetched again.
+func (p CachedProvider) Flush() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for name := range p.entries {
+		delete(p.entries, name)
+	}
+}
+
+// Drop the key, or every key under it when it ends in a /.  The Summary
+// and Info are made of keys, so they go too.
+func (p CachedProvider) Invalidate(key string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for name := range p.entries {
+		if name == key || (strings.HasSuffix(key, "/") && strings.HasPrefix(name, key)) {
+			delete(p.entries, name)
+		}
+	}
+	delete(p.entries, summaryCacheKey)
+	delete(p.entries, infoCacheKey)
+}
+
+func (p CachedProvider) Get(ctx context.Context, key string) (string, error) {
+	value, err := p.cached(ctx, key, p.ttlOf(key), func(ctx context.Context) (interface{}, error) {
+		return p.Cloud.Get(ctx, key)
+	})
+	if err != nil {
+		return "", err
+	}
+	return value.(string), nil
+}
+
+func (p CachedProvider) Summary(ctx context.Context) (*Summary, error) {
+	value, err := p.cached(ctx, summaryCacheKey, p.config.ttl, func(ctx context.Context) (interface{}, error) {
+		return p.Cloud.Summary(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Summary), nil
+}
+
+func (p CachedProvider) Info(ctx context.Context) (*Info, error) {
+	value, err := p.cached(ctx, infoCacheKey, p.config.ttl, func(ctx context.Context) (interface{}, error) {
+		return p.Cloud.Info(ctx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return value.(*Info), nil
+}
+
+// Detect's result, kept for a while by programs that would otherwise
+// detect on every request.  Errors are not kept, so that a program started
+// before the metadata service is up finds it once it is.  One detection
+// runs at a time, which the other callers wait for.
+type DetectionCache struct {
+	ttl     time.Duration
+	opts    []Option
+	lock    *sync.Mutex
+	current *cacheEntry
+}
+
+// How Detect is run, which tests replace.
+var cacheDetect = Detect
+
+// A DetectionCache that keeps the Cloud Detect returns, with opts, for
+// ttl.
+func NewDetectionCache(ttl time.Duration, opts ...Option) DetectionCache {
+	return DetectionCache{ttl: ttl, opts: opts, lock: &sync.Mutex{}, current: &cacheEntry{}}
+}
+
+func (c DetectionCache) Detect(ctx context.Context) (Cloud, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.current.value != nil && cacheNow().Sub(c.current.fetched) < c.ttl {
+		return c.current.value.(Cloud), nil
+	}
+	cloud, err := cacheDetect(ctx, c.opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.current.value = cloud
+	c.current.fetched = cacheNow()
+	return cloud, nil
+}
+
+// Detect again on the next call.
+func (c DetectionCache) Flush() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.current.value = nil
+}
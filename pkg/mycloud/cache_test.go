@@ -0,0 +1,196 @@
+package mycloud
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Cloud whose keys are the number of times they were fetched.
+type countingCloud struct {
+	Cloud
+	lock    sync.Mutex
+	fetches map[string]int
+	fail    bool
+	fetched chan string
+}
+
+func (c *countingCloud) Get(ctx context.Context, key string) (string, error) {
+	c.lock.Lock()
+	defer func() {
+		c.lock.Unlock()
+		if c.fetched != nil {
+			c.fetched <- key
+		}
+	}()
+	if c.fail {
+		return "", errors.New("unavailable")
+	}
+	c.fetches[key]++
+	return string(rune('0' + c.fetches[key])), nil
+}
+
+func onCacheClock(t *testing.T) *time.Time {
+	now := time.Unix(1700000000, 0)
+	saved := cacheNow
+	cacheNow = func() time.Time { return now }
+	t.Cleanup(func() { cacheNow = saved })
+	return &now
+}
+
+func TestCachedProviderTTL(t *testing.T) {
+	now := onCacheClock(t)
+	cloud := &countingCloud{fetches: map[string]int{}}
+	p := NewCachedProvider(cloud, CacheTTL(time.Minute), CacheKeyTTL("iam/", time.Second), CacheKeyTTL("iam/info", time.Hour))
+	ctx := context.Background()
+	get := func(key string, want string) {
+		t.Helper()
+		if got, err := p.Get(ctx, key); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", key, got, err, want)
+		}
+	}
+	get("instance-id", "1")
+	get("iam/security-credentials/role", "1")
+	get("iam/info", "1")
+	*now = now.Add(2 * time.Second)
+	get("instance-id", "1")
+	get("iam/security-credentials/role", "2")
+	get("iam/info", "1")
+	*now = now.Add(time.Minute)
+	get("instance-id", "2")
+	get("iam/info", "1")
+
+	// Errors are not kept.
+	cloud.fail = true
+	*now = now.Add(time.Minute)
+	if _, err := p.Get(ctx, "instance-id"); err == nil {
+		t.Error("an expired value was returned after the fetch failed")
+	}
+	cloud.fail = false
+	get("instance-id", "3")
+}
+
+func TestCachedProviderStale(t *testing.T) {
+	now := onCacheClock(t)
+	cloud := &countingCloud{fetches: map[string]int{}, fetched: make(chan string, 10)}
+	p := NewCachedProvider(cloud, CacheTTL(time.Minute), CacheStale(time.Minute))
+	ctx := context.Background()
+	if got, _ := p.Get(ctx, "region"); got != "1" {
+		t.Fatalf("got %q", got)
+	}
+	<-cloud.fetched
+
+	// Stale, so the old value comes back at once and one refresh runs.
+	*now = now.Add(90 * time.Second)
+	for i := 0; i < 3; i++ {
+		if got, _ := p.Get(ctx, "region"); got != "1" {
+			t.Errorf("stale get %d: got %q", i, got)
+		}
+	}
+	<-cloud.fetched
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if got, _ := p.Get(ctx, "region"); got == "2" {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("after the refresh: got %q", got)
+		}
+	}
+	select {
+	case key := <-cloud.fetched:
+		t.Errorf("%s was fetched again", key)
+	default:
+	}
+
+	// Past the stale window it is fetched while the caller waits.
+	*now = now.Add(3 * time.Minute)
+	if got, _ := p.Get(ctx, "region"); got != "3" {
+		t.Errorf("expired: got %q", got)
+	}
+}
+
+func TestCachedProviderFlush(t *testing.T) {
+	onCacheClock(t)
+	cloud := &countingCloud{fetches: map[string]int{}}
+	p := NewCachedProvider(cloud)
+	ctx := context.Background()
+	get := func(key string, want string) {
+		t.Helper()
+		if got, err := p.Get(ctx, key); err != nil || got != want {
+			t.Errorf("%s: got %q, %v, want %q", key, got, err, want)
+		}
+	}
+	for _, key := range []string{"instance-id", "placement/region", "placement/zone"} {
+		get(key, "1")
+	}
+	p.Invalidate("instance-id")
+	get("instance-id", "2")
+	get("placement/region", "1")
+	p.Invalidate("placement/")
+	get("placement/region", "2")
+	get("placement/zone", "2")
+	get("instance-id", "2")
+	p.Flush()
+	get("instance-id", "3")
+	get("placement/region", "3")
+}
+
+func TestCacheTokenTTL(t *testing.T) {
+	now := onCacheClock(t)
+	cloud := &countingCloud{fetches: map[string]int{}}
+	p := NewCachedProvider(cloud, CacheTTL(time.Hour), CacheTokenTTL(time.Minute), CacheKeyTTL("instance/service-accounts/default/token", time.Second))
+	ctx := context.Background()
+	keys := []string{"instance-id", "iam/security-credentials/role", "instance/service-accounts/default/identity", "instance/service-accounts/default/token"}
+	for _, key := range keys {
+		p.Get(ctx, key)
+	}
+	*now = now.Add(2 * time.Minute)
+	for _, key := range keys {
+		p.Get(ctx, key)
+	}
+	want := []int{1, 2, 2, 2}
+	for i, key := range keys {
+		if cloud.fetches[key] != want[i] {
+			t.Errorf("%s was fetched %d times, want %d", key, cloud.fetches[key], want[i])
+		}
+	}
+}
+
+func TestDetectionCache(t *testing.T) {
+	now := onCacheClock(t)
+	detections := 0
+	fail := false
+	saved := cacheDetect
+	cacheDetect = func(ctx context.Context, opts ...Option) (Cloud, error) {
+		if fail {
+			return nil, &DetectionError{ExitCode: detectionFailedExitCode(nil)}
+		}
+		detections++
+		return &countingCloud{}, nil
+	}
+	t.Cleanup(func() { cacheDetect = saved })
+
+	c := NewDetectionCache(time.Minute)
+	ctx := context.Background()
+	first, _ := c.Detect(ctx)
+	if again, _ := c.Detect(ctx); again != first || detections != 1 {
+		t.Errorf("detected %d times within the TTL", detections)
+	}
+	*now = now.Add(2 * time.Minute)
+	c.Detect(ctx)
+	c.Flush()
+	c.Detect(ctx)
+	if detections != 3 {
+		t.Errorf("detected %d times, want 3", detections)
+	}
+	fail = true
+	c.Flush()
+	if _, err := c.Detect(ctx); err == nil {
+		t.Error("a failed detection was not returned")
+	}
+	fail = false
+	if _, err := c.Detect(ctx); err != nil || detections != 4 {
+		t.Errorf("the failure was kept: %v", err)
+	}
+}
@@ -0,0 +1,127 @@
+package mycloud
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////
+// Apache CloudStack
+/////////////////////////////////////////////////////////
+type CloudStackCloud struct {
+	SimpleUrlBasedCloud
+}
+
+// The metadata server runs on the virtual router rather than a link local
+// address.  Newer routers resolve the data-server name for their guests,
+// older ones have to be found as the DHCP server in the lease files.
+func NewCloudStackCloud() CloudStackCloud {
+	c := CloudStackCloud{}
+	c.setFingerprint(fingerprintFor("cloudstack"))
+	c.supportsKey = true
+	return c
+}
+
+func (c *CloudStackCloud) detectEffectiveCloud(ctx context.Context) {
+	c.SimpleUrlBasedCloud.detectEffectiveCloud(ctx)
+	if c.isMyCloud {
+		c.signal = signalDNS
+		return
+	}
+	baseUrl, testUrl := c.baseUrl, c.testUrl
+	for _, router := range dhcpServers(c.fingerprint.Files) {
+		c.baseUrl = withHost(baseUrl, router)
+		c.testUrl = withHost(testUrl, router)
+		c.SimpleUrlBasedCloud.detectEffectiveCloud(ctx)
+		if c.isMyCloud {
+			c.signal = signalDHCP
+			return
+		}
+	}
+	c.baseUrl, c.testUrl = baseUrl, testUrl
+}
+
+// The user data sits beside the meta-data tree rather than in it.
+func (c *CloudStackCloud) getKey(ctx context.Context, key string) (*string, error) {
+	if key == "user-data" {
+		u, _ := url.Parse(c.baseUrl)
+		metadata, _, err := getUrl(ctx, withHost(c.fingerprint.Urls["user-data"], u.Hostname()), c.headers)
+		return metadata, err
+	}
+	return c.SimpleUrlBasedCloud.getKey(ctx, key)
+}
+
+func withHost(rawUrl string, host string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	if port := u.Port(); port != "" {
+		host = net.JoinHostPort(host, port)
+	}
+	u.Host = host
+	return u.String()
+}
+
+// The DHCP server addresses found in dhclient, NetworkManager and
+// systemd-networkd lease files.
+func dhcpServers(patterns []string) []string {
+	seen := map[string]bool{}
+	servers := []string{}
+	for _, pattern := range patterns {
+		paths, _ := filepath.Glob(pattern)
+		for _, path := range paths {
+			for _, server := range leaseServers(path) {
+				if !seen[server] {
+					seen[server] = true
+					servers = append(servers, server)
+				}
+			}
+		}
+	}
+	return servers
+}
+
+func leaseServers(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	servers := []string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		var addr string
+		if strings.HasPrefix(line, "option dhcp-server-identifier ") {
+			addr = strings.TrimSuffix(strings.TrimPrefix(line, "option dhcp-server-identifier "), ";")
+		} else if strings.HasPrefix(line, "SERVER_ADDRESS=") {
+			addr = strings.TrimPrefix(line, "SERVER_ADDRESS=")
+		}
+		if net.ParseIP(addr) != nil {
+			servers = append(servers, addr)
+		}
+	}
+	return servers
+}
+
+func (c *CloudStackCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "vm-id", nil},
+		{"instance_type", "service-offering", nil},
+		{"zone", "availability-zone", nil},
+		{"private_ip", "local-ipv4", nil},
+		{"public_ip", "public-ipv4", nil},
+		{"hostname", "local-hostname", nil},
+	}
+}
+
+func (c *CloudStackCloud) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,92 @@
+package mycloud
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Files that container runtimes drop into the root of a container.
+var containerFiles = []struct {
+	path    string
+	runtime string
+}{
+	{"/.dockerenv", "docker"},
+	{"/run/.containerenv", "podman"},
+}
+
+// Names that show up in the cgroup or mount paths of a container.  The
+// orchestrator is the last resort when the runtime cannot be told.
+var containerMarkers = []struct {
+	marker  string
+	runtime string
+}{
+	{"libpod", "podman"},
+	{"docker", "docker"},
+	{"containerd", "containerd"},
+	{"crio", "cri-o"},
+	{"kubepods", "kubernetes"},
+}
+
+// A best effort guess at the container runtime we run in, empty when we
+// are not in a container.  With cgroup v2 /proc/1/cgroup only says "/", so
+// the root file system's mount is looked at as well.
+func containerRuntime() string {
+	for _, f := range containerFiles {
+		if _, err := os.Stat(f.path); err == nil {
+			return f.runtime
+		}
+	}
+	if cgroup, err := ioutil.ReadFile("/proc/1/cgroup"); err == nil {
+		if runtime := containerMarker(string(cgroup)); runtime != "" {
+			return runtime
+		}
+	}
+	return containerMarker(rootMount())
+}
+
+func containerMarker(s string) string {
+	for _, m := range containerMarkers {
+		if strings.Contains(s, m.marker) {
+			return m.runtime
+		}
+	}
+	return ""
+}
+
+// Only the root mount counts: a host running containers has their overlay
+// mounts in its table too.
+func rootMount() string {
+	mountinfo, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(mountinfo), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == "/" {
+			return line
+		}
+	}
+	return ""
+}
+
+func inContainer() bool {
+	return containerRuntime() != ""
+}
+
+// The container is a separate dimension from the cloud: AWS from a
+// container and AWS from the host are the same cloud but not the same
+// environment.  Clouds that only run containers report it themselves.
+func reportContainer(cd CloudDetector) {
+	if cd.cloudAttributes()["environment"] != "" {
+		return
+	}
+	runtime := containerRuntime()
+	if runtime == "" {
+		cd.setAttribute("environment", "host")
+		return
+	}
+	logOutput("Running in a %s container\n", runtime)
+	cd.setAttribute("environment", "container")
+	cd.setAttribute("environment.runtime", runtime)
+}
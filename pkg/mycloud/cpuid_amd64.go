@@ -0,0 +1,21 @@
+package mycloud
+
+import "encoding/binary"
+
+func cpuid(leaf uint32, subleaf uint32) (eax uint32, ebx uint32, ecx uint32, edx uint32)
+
+// Hypervisors set bit 31 of ECX in leaf 1 and put a 12 byte signature in
+// EBX, ECX and EDX of leaf 0x40000000.  This works where DMI is hidden or
+// rewritten, in containers and for non-root users alike.
+func cpuidSignature() string {
+	_, _, ecx, _ := cpuid(1, 0)
+	if ecx&(1<<31) == 0 {
+		return ""
+	}
+	_, ebx, ecx, edx := cpuid(0x40000000, 0)
+	sig := make([]byte, 12)
+	binary.LittleEndian.PutUint32(sig[0:], ebx)
+	binary.LittleEndian.PutUint32(sig[4:], ecx)
+	binary.LittleEndian.PutUint32(sig[8:], edx)
+	return string(sig)
+}
@@ -0,0 +1,8 @@
+//go:build !amd64
+
+package mycloud
+
+// CPUID is an x86 instruction.
+func cpuidSignature() string {
+	return ""
+}
@@ -0,0 +1,212 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Temporary credentials of the instance's role, in the form the AWS CLI
+// and SDKs take from a credential_process.
+type AWSCredentials struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken"`
+	Expiration      string `json:"Expiration"`
+}
+
+// An instance has at most one role, which security-credentials/ lists.
+// The metadata service refreshes the credentials well before they expire,
+// so expired ones mean it has not managed to.
+func (c *AWSCloud) roleCredentials(ctx context.Context) (*AWSCredentials, error) {
+	listing, err := c.getKey(ctx, "iam/security-credentials/")
+	if err != nil {
+		return nil, err
+	}
+	role := strings.TrimSpace(strings.SplitN(*listing, "\n", 2)[0])
+	if role == "" {
+		return nil, errors.New("The instance has no IAM role")
+	}
+	doc, err := c.getKey(ctx, "iam/security-credentials/"+role)
+	if err != nil {
+		return nil, err
+	}
+	var creds struct {
+		Code            string `json:"Code"`
+		AccessKeyId     string `json:"AccessKeyId"`
+		SecretAccessKey string `json:"SecretAccessKey"`
+		Token           string `json:"Token"`
+		Expiration      string `json:"Expiration"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &creds); err != nil {
+		return nil, err
+	}
+	if creds.Code != "" && creds.Code != "Success" {
+		return nil, errors.New("The credentials of the role " + role + " are not available: " + creds.Code)
+	}
+	expiration, err := time.Parse(time.RFC3339, creds.Expiration)
+	if err != nil {
+		return nil, errors.New("Invalid expiration " + creds.Expiration + " of the credentials of the role " + role)
+	}
+	if !time.Now().Before(expiration) {
+		return nil, errors.New("The credentials of the role " + role + " expired at " + creds.Expiration)
+	}
+	logOutput("The credentials of the role %s expire at %s\n", role, creds.Expiration)
+	return &AWSCredentials{
+		Version:         1,
+		AccessKeyId:     creds.AccessKeyId,
+		SecretAccessKey: creds.SecretAccessKey,
+		SessionToken:    creds.Token,
+		Expiration:      creds.Expiration,
+	}, nil
+}
+
+func writeAWSCredentials(creds *AWSCredentials) error {
+	if globalOpts.format == "env" {
+		fmt.Printf("AWS_ACCESS_KEY_ID=%s\n", envQuote(creds.AccessKeyId))
+		fmt.Printf("AWS_SECRET_ACCESS_KEY=%s\n", envQuote(creds.SecretAccessKey))
+		fmt.Printf("AWS_SESSION_TOKEN=%s\n", envQuote(creds.SessionToken))
+		fmt.Printf("AWS_CREDENTIAL_EXPIRATION=%s\n", envQuote(creds.Expiration))
+		return nil
+	}
+	out, err := json.MarshalIndent(creds, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+// An OAuth2 access token, as the GCE metadata server returns it.  Azure
+// adds the resource it is for.
+type AccessToken struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int64  `json:"expires_in"`
+	TokenType   string `json:"token_type"`
+	Resource    string `json:"resource,omitempty"`
+}
+
+// Scripts mostly want the bare token for an Authorization header, so that
+// is what the text format prints.
+func writeAccessToken(token *AccessToken) error {
+	switch globalOpts.format {
+	case "json":
+		out, err := json.MarshalIndent(token, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", out)
+	case "env":
+		fmt.Printf("MYCLOUD_ACCESS_TOKEN=%s\n", envQuote(token.AccessToken))
+		fmt.Printf("MYCLOUD_TOKEN_TYPE=%s\n", envQuote(token.TokenType))
+		fmt.Printf("MYCLOUD_TOKEN_EXPIRES_IN=%s\n", envQuote(strconv.FormatInt(token.ExpiresIn, 10)))
+	default:
+		fmt.Printf("%s\n", token.AccessToken)
+	}
+	return nil
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// A token for the default service account, limited to -scopes when they
+// are given.  Scopes the account was not granted are refused by the APIs,
+// not by the metadata server.
+func (c *GCECloud) accessToken(ctx context.Context, scopes []string) (*AccessToken, error) {
+	key := "instance/service-accounts/default/token"
+	if len(scopes) > 0 {
+		key += "?scopes=" + url.QueryEscape(strings.Join(scopes, ","))
+	}
+	doc, err := c.getKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var token AccessToken
+	if err := json.Unmarshal([]byte(*doc), &token); err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+const azureIdentityApiVersion = "2018-02-01"
+
+// A managed identity token for the -resource, by default the resource
+// manager of the environment the VM is in.  VMs with several user assigned
+// identities pick one with -client-id.
+func (c *AzureCloud) accessToken(ctx context.Context, resource string, clientId string) (*AccessToken, error) {
+	if resource == "" {
+		resource = c.environment.resourceManagerEndpoint
+	}
+	if resource == "" {
+		return nil, errors.New("There is no default resource in " + c.environment.name + ", give one with -resource")
+	}
+	query := url.Values{}
+	query.Set("api-version", azureIdentityApiVersion)
+	query.Set("resource", resource)
+	if clientId != "" {
+		query.Set("client_id", clientId)
+	}
+	doc, _, err := getUrl(ctx, c.fingerprint.Urls["token"]+"?"+query.Encode(), c.fingerprint.Headers)
+	if err != nil {
+		return nil, err
+	}
+	// The numbers are strings.
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   string `json:"expires_in"`
+		TokenType   string `json:"token_type"`
+		Resource    string `json:"resource"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &token); err != nil {
+		return nil, err
+	}
+	expiresIn, _ := strconv.ParseInt(token.ExpiresIn, 10, 64)
+	return &AccessToken{token.AccessToken, expiresIn, token.TokenType, token.Resource}, nil
+}
+
+// Print the credentials the instance was given: the AWS role's keys, or an
+// access token where the cloud hands out tokens.
+func runCreds(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	var err error
+	switch c := cd.(type) {
+	case *AWSCloud:
+		var creds *AWSCredentials
+		if creds, err = c.roleCredentials(ctx); err == nil {
+			err = writeAWSCredentials(creds)
+		}
+	case *GCECloud:
+		var token *AccessToken
+		if token, err = c.accessToken(ctx, globalOpts.scopes); err == nil {
+			err = writeAccessToken(token)
+		}
+	case *AzureCloud:
+		var token *AccessToken
+		if token, err = c.accessToken(ctx, globalOpts.resource, globalOpts.clientId); err == nil {
+			err = writeAccessToken(token)
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Credentials are not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the credentials: %s\n", err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	return foundExitCode
+}
@@ -0,0 +1,74 @@
+package mycloud
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"strings"
+)
+
+// User data and custom metadata are often base64 encoded, and sometimes
+// gzipped before that, so -decode takes the steps to undo in order, e.g.
+// base64,gzip.
+var decoders = map[string]func([]byte) ([]byte, error){
+	"base64": decodeBase64,
+	"gzip":   decodeGzip,
+}
+
+// Padding and line breaks vary between clouds, and some use the URL safe
+// alphabet.
+func decodeBase64(data []byte) ([]byte, error) {
+	s := strings.Join(strings.Fields(string(data)), "")
+	s = strings.TrimRight(s, "=")
+	if out, err := base64.RawStdEncoding.DecodeString(s); err == nil {
+		return out, nil
+	}
+	out, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, errors.New("The value is not base64 encoded")
+	}
+	return out, nil
+}
+
+func decodeGzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("The value is not gzipped")
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+type DecodeError struct {
+	key string
+	err error
+}
+
+func (e *DecodeError) Error() string {
+	return "Could not decode the key " + e.key + ": " + e.err.Error()
+}
+
+func parseDecoders(steps string) ([]string, error) {
+	var names []string
+	for _, name := range strings.Split(steps, ",") {
+		name = strings.TrimSpace(name)
+		if _, ok := decoders[name]; !ok {
+			return nil, errors.New("Unknown decoding " + name)
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+func decodeValue(value string, steps []string) (string, error) {
+	data := []byte(value)
+	for _, name := range steps {
+		var err error
+		if data, err = decoders[name](data); err != nil {
+			return "", err
+		}
+	}
+	return string(data), nil
+}
@@ -0,0 +1,143 @@
+package mycloud
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const dmiDir = "/sys/class/dmi/id"
+
+var dmiFields = []string{
+	"sys_vendor",
+	"product_name",
+	"product_version",
+	"product_serial",
+	"chassis_vendor",
+	"chassis_asset_tag",
+	"board_vendor",
+	"bios_vendor",
+	"bios_version",
+}
+
+// The DMI/SMBIOS strings the hypervisor shows to the guest.  Fields that
+// cannot be read (not Linux, not root for some of them) are left out.
+func readDMI() map[string]string {
+	dmi := map[string]string{}
+	for _, field := range dmiFields {
+		data, err := ioutil.ReadFile(filepath.Join(dmiDir, field))
+		if err == nil {
+			dmi[field] = strings.TrimSpace(string(data))
+		}
+	}
+	return dmi
+}
+
+func dmiMatches(dmi map[string]string, patterns []string) bool {
+	for _, value := range dmi {
+		for _, pattern := range patterns {
+			if strings.Contains(strings.ToLower(value), strings.ToLower(pattern)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// The cloud whose DMI fingerprint matches, if any.
+func dmiCloud(dmi map[string]string) string {
+	for _, id := range sortedFingerprintIds() {
+		fp := loadedFingerprints().Clouds[id]
+		if len(fp.DMI) > 0 && dmiMatches(dmi, fp.DMI) {
+			return fp.Name
+		}
+	}
+	return ""
+}
+
+// Whether the cloud is found by asking a metadata service, rather than by
+// reading files or the environment, which costs nothing.
+func probesNetwork(fp Fingerprint) bool {
+	return fp.BaseUrl != "" || fp.TestUrl != ""
+}
+
+// The clouds not worth probing over the network.  When the DMI data names a
+// cloud with a metadata service the others cannot answer, and waiting for
+// them to time out is most of the time detection takes.  Without DMI data,
+// or when it names none of them, e.g. bare metal, a private OpenStack or
+// Equinix Metal, everything is probed.  Hypervisors name no cloud, since
+// clouds run on them.
+func ruledOutByDMI(dmi map[string]string, cdList []CloudDetector) []bool {
+	ruledOut := make([]bool, len(cdList))
+	named := namedByDMI(dmi, cdList)
+	if len(named) == 0 {
+		return ruledOut
+	}
+	for i, cd := range cdList {
+		fp := cd.cloudFingerprint()
+		ruledOut[i] = probesNetwork(fp) && !named[fp.ID]
+	}
+	return ruledOut
+}
+
+// The ids of the network clouds the DMI data is the DMI data of.
+func namedByDMI(dmi map[string]string, cdList []CloudDetector) map[string]bool {
+	named := map[string]bool{}
+	if len(dmi) == 0 {
+		return named
+	}
+	for _, cd := range cdList {
+		fp := cd.cloudFingerprint()
+		if probesNetwork(fp) && len(fp.DMI) > 0 && dmiMatches(dmi, fp.DMI) {
+			named[fp.ID] = true
+		}
+	}
+	return named
+}
+
+var dmiHypervisors = []struct {
+	pattern string
+	name    string
+}{
+	{"QEMU", "KVM"},
+	{"KVM", "KVM"},
+	{"Bochs", "KVM"},
+	{"VMware", "VMware"},
+	{"VirtualBox", "VirtualBox"},
+	{"Xen", "Xen"},
+	{"Microsoft Corporation", "Hyper-V"},
+}
+
+func dmiHypervisor(dmi map[string]string) string {
+	for _, h := range dmiHypervisors {
+		if dmiMatches(dmi, []string{h.pattern}) {
+			return h.name
+		}
+	}
+	return ""
+}
+
+// When the metadata service belongs to one cloud but the virtual hardware
+// belongs to another platform we are nested: a KVM guest on GCE reaching
+// the GCE metadata server, or OpenStack running on AWS.  Report both layers
+// instead of pretending there is only the outer one.
+func reportLayers(cd CloudDetector) {
+	fp := cd.cloudFingerprint()
+	if len(fp.DMI) == 0 {
+		return
+	}
+	dmi := readDMI()
+	if len(dmi) == 0 || dmiMatches(dmi, fp.DMI) {
+		return
+	}
+	inner := dmiCloud(dmi)
+	if inner == "" {
+		inner = dmiHypervisor(dmi)
+	}
+	if inner == "" {
+		return
+	}
+	logOutput("The hardware looks like %s but the metadata service is %s\n", inner, cd.cloudDescription())
+	cd.setAttribute("platform.inner", inner)
+	cd.setAttribute("platform.outer", cd.cloudDescription())
+}
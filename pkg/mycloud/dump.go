@@ -0,0 +1,194 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+// Clouds whose whole metadata can be gathered into one document, for
+// inventory snapshots.
+type Dumper interface {
+	dump(context.Context) (interface{}, error)
+}
+
+// Credentials are served in the same tree as everything else, but have no
+// place in a snapshot that is meant to be stored and passed around.
+var dumpSkipped = []string{
+	"iam/security-credentials/",
+	"identity-credentials/",
+}
+
+func hasAnyPrefix(key string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func fetchDocument(ctx context.Context, url string, headers map[string]string) (interface{}, error) {
+	doc, _, err := getUrl(ctx, url, headers)
+	return provider.ParseDocument(doc, err)
+}
+
+func runDump(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	dumper, ok := dumperOf(cd)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Dumping the metadata is not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	doc, err := dumper.dump(ctx)
+	if err != nil {
+		logOutput("Failed to dump the metadata.  Error: %s\n", err)
+		status.Error = err.Error()
+		fmt.Printf("UNKNOWN\n")
+		return keyErrorExitCode(err)
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		status.Error = err.Error()
+		return errorExitCode
+	}
+	fmt.Printf("%s\n", out)
+	return foundExitCode
+}
+
+// Clouds with tree structured metadata can fetch a whole subtree, which
+// -key asks for with a trailing /.
+type SubtreeGetter interface {
+	getSubtree(ctx context.Context, dir string) (interface{}, error)
+}
+
+// A key with a trailing / is fetched with everything under it as a JSON
+// object where the cloud can, rather than as the directory's listing.
+func fetchKey(ctx context.Context, cd CloudDetector, key string) (*string, error) {
+	getter, ok := cd.(SubtreeGetter)
+	if !ok || !strings.HasSuffix(key, "/") {
+		return cd.getKey(ctx, key)
+	}
+	tree, err := getter.getSubtree(ctx, strings.TrimLeft(key, "/"))
+	if err != nil {
+		return nil, err
+	}
+	out, err := json.MarshalIndent(tree, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	s := string(out)
+	return &s, nil
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+func (c *AWSCloud) dump(ctx context.Context) (interface{}, error) {
+	tree, err := walkTree(ctx, c, "", dumpSkipped)
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]interface{}{"meta-data": tree}
+	if identity, err := fetchDocument(ctx, c.fingerprint.Urls["identity"], c.headers); err == nil {
+		out["identity"] = identity
+	}
+	return out, nil
+}
+
+func (c *AWSCloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return walkTree(ctx, c, dir, nil)
+}
+
+/////////////////////////////////////////////////////////
+// EC2 style trees
+/////////////////////////////////////////////////////////
+func (c *EC2CompatibleCloud) dump(ctx context.Context) (interface{}, error) {
+	return walkTree(ctx, c, "", dumpSkipped)
+}
+
+func (c *EC2CloneCloud) dump(ctx context.Context) (interface{}, error) {
+	return walkTree(ctx, c, "", dumpSkipped)
+}
+
+func (c *AlibabaCloud) dump(ctx context.Context) (interface{}, error) {
+	return walkTree(ctx, c, "", dumpSkipped)
+}
+
+func (c *CloudStackCloud) dump(ctx context.Context) (interface{}, error) {
+	return walkTree(ctx, c, "", dumpSkipped)
+}
+
+func (c *EC2CompatibleCloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return walkTree(ctx, c, dir, nil)
+}
+
+func (c *EC2CloneCloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return walkTree(ctx, c, dir, nil)
+}
+
+func (c *AlibabaCloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return walkTree(ctx, c, dir, nil)
+}
+
+func (c *CloudStackCloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return walkTree(ctx, c, dir, nil)
+}
+
+func (c *DigitalOceanCloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return walkTree(ctx, c, dir, nil)
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// The whole tree in one request.  Access tokens are not part of it.
+func (c *GCECloud) dump(ctx context.Context) (interface{}, error) {
+	return fetchDocument(ctx, c.fingerprint.BaseUrl+"?recursive=true&alt=json", c.fingerprint.Headers)
+}
+
+func (c *GCECloud) getSubtree(ctx context.Context, dir string) (interface{}, error) {
+	return fetchDocument(ctx, c.fingerprint.BaseUrl+gceKeyPath(dir)+"?recursive=true&alt=json", c.fingerprint.Headers)
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+func (c *AzureCloud) dump(ctx context.Context) (interface{}, error) {
+	return fetchDocument(ctx, c.fingerprint.BaseUrl+"?api-version="+c.apiVersion, c.fingerprint.Headers)
+}
+
+/////////////////////////////////////////////////////////
+// JSON documents
+/////////////////////////////////////////////////////////
+func (c *OpenStackCloud) dump(ctx context.Context) (interface{}, error) {
+	if c.metadata != nil {
+		return provider.ParseDocument(c.metadata, nil)
+	}
+	return fetchDocument(ctx, c.testUrl, c.headers)
+}
+
+// The tree is also served as a single document next to it.
+func (c *DigitalOceanCloud) dump(ctx context.Context) (interface{}, error) {
+	return fetchDocument(ctx, strings.TrimSuffix(c.baseUrl, "/")+".json", c.headers)
+}
+
+func (c *EquinixCloud) dump(ctx context.Context) (interface{}, error) {
+	return fetchDocument(ctx, c.testUrl, c.headers)
+}
+
+func (c *OCICloud) dump(ctx context.Context) (interface{}, error) {
+	return fetchDocument(ctx, c.baseUrl, c.headers)
+}
+
+func (c *ECSCloud) dump(ctx context.Context) (interface{}, error) {
+	return provider.DumpResources(ctx, c.getKey, []string{"container", "task"})
+}
@@ -0,0 +1,114 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// AWS ECS tasks
+/////////////////////////////////////////////////////////
+type ECSCloud struct {
+	BaseCloud
+	metadataUri string
+}
+
+// The agent tells each container where its task metadata endpoint is.
+// Version 3 is only used when version 4 is not offered.
+var ecsMetadataEnv = []string{"ECS_CONTAINER_METADATA_URI_V4", "ECS_CONTAINER_METADATA_URI"}
+
+// The EC2 metadata service is often blocked from tasks, and is not there
+// at all on Fargate, so tasks are recognised by their own endpoint.
+func NewECSCloud() ECSCloud {
+	c := ECSCloud{}
+	c.fingerprint = fingerprintFor("ecs")
+	c.name = c.fingerprint.Name
+	c.supportsKey = true
+	return c
+}
+
+func (c *ECSCloud) detectEffectiveCloud(ctx context.Context) {
+	c.isMyCloud = false
+	for _, name := range ecsMetadataEnv {
+		if uri := os.Getenv(name); uri != "" {
+			c.metadataUri = strings.TrimRight(uri, "/")
+			break
+		}
+	}
+	if c.metadataUri == "" {
+		return
+	}
+	doc, _, err := getUrl(ctx, c.metadataUri, nil)
+	if err == nil {
+		var container struct {
+			DockerId string `json:"DockerId"`
+		}
+		if json.Unmarshal([]byte(*doc), &container) != nil || container.DockerId == "" {
+			err = &InvalidResponseError{URL: c.metadataUri}
+		}
+	}
+	c.isMyCloud = err == nil || provider.IsThrottled(err)
+	c.probeError = err
+	if err != nil {
+		return
+	}
+
+	task, _, err := getUrl(ctx, c.metadataUri+"/task", nil)
+	if err != nil {
+		return
+	}
+	var t struct {
+		Cluster    string `json:"Cluster"`
+		LaunchType string `json:"LaunchType"`
+	}
+	if json.Unmarshal([]byte(*task), &t) == nil {
+		c.setAttribute("ecs.cluster", t.Cluster)
+		c.setAttribute("ecs.launch-type", t.LaunchType)
+		// There is no host to speak of on Fargate, the task is the machine.
+		if t.LaunchType == "FARGATE" {
+			c.name = fingerprintFor("fargate").Name
+		}
+	}
+}
+
+// Short names for the task keys that matter most where there is no EC2
+// metadata to fall back on.
+var ecsKeyAliases = map[string]string{
+	"cluster":           "task/Cluster",
+	"task-arn":          "task/TaskARN",
+	"availability-zone": "task/AvailabilityZone",
+}
+
+// Keys under container/ are paths into this container's metadata, other
+// keys name an endpoint (task, stats, task/stats) followed by a path into
+// its document, e.g. task/Cluster or container/Networks/0/IPv4Addresses/0.
+func (c *ECSCloud) getKey(ctx context.Context, key string) (*string, error) {
+	if alias, ok := ecsKeyAliases[key]; ok {
+		key = alias
+	}
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	if parts[0] == "container" {
+		doc, _, err := getUrl(ctx, c.metadataUri, nil)
+		if err != nil {
+			return nil, err
+		}
+		return provider.LookupJSON(*doc, parts[1:])
+	}
+	return provider.ResourceKey(ctx, metadataClient{}, c.metadataUri+"/", "", nil, key)
+}
+
+func (c *ECSCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "task/TaskARN", nil},
+		{"zone", "task/AvailabilityZone", nil},
+		{"private_ip", "container/Networks/0/IPv4Addresses/0", nil},
+	}
+}
+
+func (c *ECSCloud) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
@@ -0,0 +1,56 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Equinix Metal (formerly Packet)
+/////////////////////////////////////////////////////////
+type EquinixCloud struct {
+	SimpleUrlBasedCloud
+}
+
+// The whole metadata is a single JSON document served over https, so keys
+// are paths into it, e.g. plan or network/bonding/mode.
+func NewEquinixCloud() EquinixCloud {
+	c := EquinixCloud{}
+	c.setFingerprint(fingerprintFor("equinix"))
+	c.supportsKey = true
+	c.validate = func(doc string) bool {
+		var m struct {
+			Id   string `json:"id"`
+			Plan string `json:"plan"`
+		}
+		return json.Unmarshal([]byte(doc), &m) == nil && m.Id != "" && m.Plan != ""
+	}
+	return c
+}
+
+func (c *EquinixCloud) getKey(ctx context.Context, key string) (*string, error) {
+	if c.metadata == nil {
+		metadata, _, err := getUrl(ctx, c.testUrl, c.headers)
+		if err != nil {
+			return nil, err
+		}
+		c.metadata = metadata
+	}
+	return lookupKey(*c.metadata, key)
+}
+
+func (c *EquinixCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "id", nil},
+		{"instance_type", "plan", nil},
+		{"region", "metro", nil},
+		{"zone", "facility", nil},
+		{"hostname", "hostname", nil},
+	}
+}
+
+func (c *EquinixCloud) tags(ctx context.Context) (map[string]string, error) {
+	return provider.JSONListTags(ctx, c.getKey, "tags")
+}
@@ -0,0 +1,55 @@
+package mycloud
+
+import "errors"
+
+// Exit codes, so that scripts can tell the ways a run can fail apart.
+const (
+	foundExitCode       = 0
+	errorExitCode       = 1
+	noCloudExitCode     = 2
+	keyMissingExitCode  = 3
+	unreachableExitCode = 4
+	usageExitCode       = 5
+	// Hybrid fleets want to tell their own servers from machines nothing
+	// was detected on, so bare metal gets an exit code of its own.
+	bareMetalExitCode = 6
+	// -verify found the identity document was not signed by the cloud.
+	unverifiedExitCode = 7
+)
+
+// No cloud was found, but one that looked like it should have been could
+// not reach its metadata service, e.g. AWS with a hop limit of 1.
+func detectionFailedExitCode(cdList []CloudDetector) int {
+	for _, cd := range cdList {
+		if v, ok := cd.(Verifier); ok && v.verificationFailed() {
+			return unverifiedExitCode
+		}
+	}
+	for _, cd := range cdList {
+		if cd.cloudDiagnostic() != "" {
+			return unreachableExitCode
+		}
+	}
+	return noCloudExitCode
+}
+
+// A key that could not be fetched because the metadata service did not
+// answer, rather than because the service said there is no such key.  A
+// value that could not be decoded is neither, nor is a walk that reached
+// -walk-max-keys.
+func keyErrorExitCode(err error) int {
+	var decodeErr *DecodeError
+	var limitErr *WalkLimitError
+	var verificationErr *VerificationError
+	switch {
+	case errors.As(err, &decodeErr), errors.As(err, &limitErr):
+		return errorExitCode
+	case errors.As(err, &verificationErr):
+		return unverifiedExitCode
+	}
+	switch classifyError(err) {
+	case ErrorCategoryDNS, ErrorCategoryConnect, ErrorCategoryTLS, ErrorCategoryTimeout, ErrorCategoryThrottled:
+		return unreachableExitCode
+	}
+	return keyMissingExitCode
+}
@@ -0,0 +1,102 @@
+package mycloud
+
+import (
+	_ "embed"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+// Endpoints and other signatures of every cloud.  The defaults are built in
+// and files in fingerprintsDir can change them or describe new clouds that
+// are detected with a plain http get, without shipping a new binary.
+//
+//go:embed fingerprints.json
+var embeddedFingerprints []byte
+
+const fingerprintsDir = "/etc/mycloud/fingerprints.d"
+
+type Fingerprint = provider.Fingerprint
+
+type FingerprintDB struct {
+	Version int                    `json:"version"`
+	Clouds  map[string]Fingerprint `json:"clouds"`
+	// What each signal adds to the confidence of the matches that rest
+	// on it, see signalWeight.
+	Weights map[string]int `json:"weights,omitempty"`
+}
+
+var fingerprintsOnce sync.Once
+var fingerprints *FingerprintDB
+
+// The fingerprints, loaded on first use.  Library callers may detect from
+// several goroutines at once.
+func loadedFingerprints() *FingerprintDB {
+	fingerprintsOnce.Do(func() {
+		fingerprints = loadFingerprints(fingerprintsDir)
+	})
+	return fingerprints
+}
+
+func (db *FingerprintDB) merge(o *FingerprintDB) {
+	if o.Version > db.Version {
+		db.Version = o.Version
+	}
+	for id, fp := range o.Clouds {
+		db.Clouds[id] = db.Clouds[id].Merge(fp)
+	}
+	for signal, weight := range o.Weights {
+		if !knownSignals[signal] {
+			logOutput("Ignoring the weight of the unknown signal %s\n", signal)
+			continue
+		}
+		if db.Weights == nil {
+			db.Weights = map[string]int{}
+		}
+		db.Weights[signal] = weight
+	}
+}
+
+// Override files are applied in lexical order so later files win.
+func loadFingerprints(dir string) *FingerprintDB {
+	db := &FingerprintDB{}
+	if err := json.Unmarshal(embeddedFingerprints, db); err != nil {
+		panic("The built in fingerprints are invalid: " + err.Error())
+	}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logOutput("Ignoring the fingerprint file %s: %s\n", path, err)
+			continue
+		}
+		o := &FingerprintDB{}
+		if err := json.Unmarshal(data, o); err != nil {
+			logOutput("Ignoring the fingerprint file %s: %s\n", path, err)
+			continue
+		}
+		db.merge(o)
+	}
+	return db
+}
+
+func sortedFingerprintIds() []string {
+	db := loadedFingerprints()
+	ids := make([]string, 0, len(db.Clouds))
+	for id := range db.Clouds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func fingerprintFor(id string) Fingerprint {
+	fp := loadedFingerprints().Clouds[id]
+	fp.ID = id
+	return fp
+}
@@ -0,0 +1,84 @@
+package mycloud
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Hyper-V
+/////////////////////////////////////////////////////////
+type HyperVCloud struct {
+	BaseCloud
+}
+
+// Hyper-V guests outside of Azure have no metadata server, but the host
+// pushes key value pairs (KVP) that hv_kvp_daemon keeps in pool files.
+func NewHyperVCloud() HyperVCloud {
+	c := HyperVCloud{}
+	c.fingerprint = fingerprintFor("hyperv")
+	c.supportsKey = true
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceLow
+	return c
+}
+
+// Azure runs on Hyper-V too, so its asset tag rules this out.
+func (c *HyperVCloud) detectEffectiveCloud(ctx context.Context) {
+	c.isMyCloud = false
+	dmi := readDMI()
+	if dmiMatches(dmi, fingerprintFor("azure").DMI) {
+		return
+	}
+	if dmiMatches(dmi, c.fingerprint.DMI) && dmi["product_name"] == "Virtual Machine" {
+		c.isMyCloud = true
+		c.signal = signalDMI
+		return
+	}
+	for _, path := range c.fingerprint.Files {
+		if _, err := os.Stat(path); err == nil {
+			c.isMyCloud = true
+			c.signal = signalFiles
+			return
+		}
+	}
+}
+
+// Each record in a pool is a NUL padded key followed by a NUL padded value.
+const (
+	kvpKeySize   = 512
+	kvpValueSize = 2048
+)
+
+func kvpLookup(pool []byte, key string) (string, bool) {
+	recordSize := kvpKeySize + kvpValueSize
+	for off := 0; off+recordSize <= len(pool); off += recordSize {
+		k := bytes.TrimRight(pool[off:off+kvpKeySize], "\x00")
+		if string(k) == key {
+			v := bytes.TrimRight(pool[off+kvpKeySize:off+recordSize], "\x00")
+			return string(v), true
+		}
+	}
+	return "", false
+}
+
+// Keys are looked up in the pools in order, so values the administrator
+// sets from the host (pool 0) come before the ones Hyper-V fills in
+// (pool 3), such as VirtualMachineName and PhysicalHostNameFullyQualified.
+func (c *HyperVCloud) getKey(ctx context.Context, key string) (*string, error) {
+	for _, path := range c.fingerprint.Files {
+		pool, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		if val, ok := kvpLookup(pool, key); ok {
+			return &val, nil
+		}
+	}
+	return nil, errors.New("No such KVP key " + key)
+}
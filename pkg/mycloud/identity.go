@@ -0,0 +1,165 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The document a cloud signs to vouch for the instance, in the same
+// envelope everywhere.  The format says what the signature or token is:
+// pkcs7 for AWS and Azure, jwt for GCE.  The document is what was signed,
+// as JSON, and for a JWT its claims.  With -verify they have been checked.
+type IdentityDocument struct {
+	Provider  string      `json:"provider"`
+	Format    string      `json:"format"`
+	Document  interface{} `json:"document,omitempty"`
+	Signature string      `json:"signature,omitempty"`
+	Token     string      `json:"token,omitempty"`
+	Verified  bool        `json:"verified,omitempty"`
+}
+
+// Clouds that hand out a signed identity document.
+type IdentityProvider interface {
+	identityDocument(context.Context) (*IdentityDocument, error)
+}
+
+func runIdentity(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	provider, ok := cd.(IdentityProvider)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Identity documents are not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	// Azure's certificates chain to CAs that are not built in.
+	if _, ok := cd.(*AzureCloud); ok && globalOpts.verify {
+		fmt.Fprintf(os.Stderr, "-verify is not supported on Azure, only on AWS and GCE\n")
+		return usageExitCode
+	}
+	if _, ok := cd.(*GCECloud); ok && globalOpts.audience == "" {
+		fmt.Fprintf(os.Stderr, "GCE identity tokens need an -audience\n")
+		return usageExitCode
+	}
+	doc, err := provider.identityDocument(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the identity document: %s\n", err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	doc.Provider = cd.cloudDescription()
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		status.Error = err.Error()
+		return errorExitCode
+	}
+	fmt.Printf("%s\n", out)
+	return foundExitCode
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+// The PKCS7 signature of the document is served beside it.
+func (c *AWSCloud) identityDocument(ctx context.Context) (*IdentityDocument, error) {
+	documentUrl := c.fingerprint.Urls["identity"]
+	document, err := fetchDocument(ctx, documentUrl, c.headers)
+	if err != nil {
+		return nil, err
+	}
+	signature, _, err := getUrl(ctx, strings.TrimSuffix(documentUrl, "document")+"pkcs7", c.headers)
+	if err != nil {
+		return nil, err
+	}
+	// -verify has checked the document while detecting AWS.
+	return &IdentityDocument{
+		Format:    "pkcs7",
+		Document:  document,
+		Signature: strings.TrimSpace(*signature),
+		Verified:  c.attributes["aws.verified"] == "true",
+	}, nil
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// The default service account's identity token is minted for the -audience
+// of whoever is going to check it.
+func (c *GCECloud) identityDocument(ctx context.Context) (*IdentityDocument, error) {
+	token, err := c.getKey(ctx, "instance/service-accounts/default/identity?format=full&audience="+url.QueryEscape(globalOpts.audience))
+	if err != nil {
+		return nil, err
+	}
+	jwt := strings.TrimSpace(*token)
+	if globalOpts.verify {
+		// Google's keys are on the internet, not the metadata server.
+		jwks, err := callApi(ctx, "GET", c.fingerprint.Urls["jwks"], nil, "")
+		if err != nil {
+			return nil, err
+		}
+		claims, err := verifyJWT(jwt, []byte(*jwks), globalOpts.audience, time.Now())
+		if err != nil {
+			return nil, &VerificationError{err.Error()}
+		}
+		return &IdentityDocument{Format: "jwt", Document: claims, Token: jwt, Verified: true}, nil
+	}
+	claims, err := jwtClaims(jwt)
+	if err != nil {
+		return nil, err
+	}
+	return &IdentityDocument{Format: "jwt", Document: claims, Token: jwt}, nil
+}
+
+// The claims are the middle of the three base64url parts of a JWT.
+func jwtClaims(jwt string) (interface{}, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("The identity token is not a JWT")
+	}
+	var claims interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+// The attested document is a PKCS7 signature with the document inside it,
+// which is taken out as the other clouds serve it.
+func (c *AzureCloud) identityDocument(ctx context.Context) (*IdentityDocument, error) {
+	doc, _, err := getUrl(ctx, c.fingerprint.Urls["attested"]+"?api-version="+c.apiVersion, c.fingerprint.Headers)
+	if err != nil {
+		return nil, err
+	}
+	var attested struct {
+		Encoding  string `json:"encoding"`
+		Signature string `json:"signature"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &attested); err != nil {
+		return nil, err
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(attested.Signature), ""))
+	if err != nil {
+		return nil, fmt.Errorf("The attested document is not base64: %s", err)
+	}
+	content, err := pkcs7Content(signature)
+	if err != nil {
+		return nil, fmt.Errorf("The attested document is not a PKCS7 signature: %s", err)
+	}
+	var document interface{}
+	if err := json.Unmarshal(content, &document); err != nil {
+		return nil, fmt.Errorf("The attested document does not sign JSON: %s", err)
+	}
+	return &IdentityDocument{Format: attested.Encoding, Document: document, Signature: attested.Signature}, nil
+}
@@ -0,0 +1,36 @@
+package mycloud
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	data, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// The attested document is signed by a test certificate, as openssl signs
+// it, with the document inside the signature.
+func TestPKCS7Content(t *testing.T) {
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(readTestdata(t, "azure-attested.b64"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := pkcs7Content(signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := readTestdata(t, "azure-document.json"); !bytes.Equal(content, want) {
+		t.Errorf("got %q, want %q", content, want)
+	}
+
+	if _, err := pkcs7Content(signature[:len(signature)/2]); err == nil {
+		t.Error("a truncated signature was accepted")
+	}
+}
@@ -0,0 +1,59 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// The same fields under the same names on every cloud, so that callers do
+// not need to know each provider's key names.  Fields a cloud does not
+// have are empty rather than left out.
+type Info struct {
+	Cloud        string `json:"cloud"`
+	InstanceId   string `json:"instance_id"`
+	InstanceType string `json:"instance_type"`
+	Region       string `json:"region"`
+	Zone         string `json:"zone"`
+	PrivateIp    string `json:"private_ip"`
+	PublicIp     string `json:"public_ip"`
+	Hostname     string `json:"hostname"`
+	AccountId    string `json:"account_id"`
+}
+
+func normalize(ctx context.Context, cd CloudDetector) Info {
+	s := summarize(ctx, cd)
+	info := Info{
+		Cloud:        s.Provider,
+		InstanceId:   s.InstanceId,
+		InstanceType: s.InstanceType,
+		Region:       s.Region,
+		Zone:         s.Zone,
+		PrivateIp:    s.PrivateIp,
+		PublicIp:     s.PublicIp,
+		Hostname:     s.Hostname,
+		AccountId:    s.AccountId,
+	}
+	// Clouds without a hostname in their metadata, such as ECS, get the
+	// one the guest was given.
+	if info.Hostname == "" {
+		info.Hostname, _ = os.Hostname()
+	}
+	return info
+}
+
+func runInfo(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	out, err := json.MarshalIndent(normalize(ctx, cd), "", "  ")
+	if err != nil {
+		status.Error = err.Error()
+		return errorExitCode
+	}
+	fmt.Printf("%s\n", out)
+	return foundExitCode
+}
@@ -0,0 +1,88 @@
+package mycloud
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+// Keys into JSON documents can be given as a path with slashes
+// (meta/cluster_name), with dots and brackets (meta.cluster_name,
+// devices[0].bus) or as a JSONPath ($.meta.cluster_name,
+// $['meta']['cluster_name']).
+func isPathQuery(key string) bool {
+	return strings.HasPrefix(key, "$") || (!strings.Contains(key, "/") && strings.ContainsAny(key, ".["))
+}
+
+// Split a dot, bracket or JSONPath key into its names.
+func parseKeyPath(key string) ([]string, error) {
+	key = strings.TrimPrefix(key, "$")
+	var path []string
+	name := ""
+	for i := 0; i < len(key); i++ {
+		switch c := key[i]; c {
+		case '.':
+			if name != "" {
+				path = append(path, name)
+			}
+			name = ""
+		case '[':
+			if name != "" {
+				path = append(path, name)
+			}
+			name = ""
+			end := strings.IndexByte(key[i:], ']')
+			if end < 0 {
+				return nil, errors.New("Unbalanced [ in the key " + key)
+			}
+			inner := key[i+1 : i+end]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				inner = inner[1 : len(inner)-1]
+			}
+			path = append(path, inner)
+			i += end
+		default:
+			name += string(c)
+		}
+	}
+	if name != "" {
+		path = append(path, name)
+	}
+	if len(path) == 0 {
+		return nil, errors.New("Empty key " + key)
+	}
+	return path, nil
+}
+
+// Look a key up in a JSON document.  Names with dots in them, such as user
+// metadata called app.version, are tried as they are before the key is
+// taken as a path.
+func lookupKey(doc string, key string) (*string, error) {
+	if !isPathQuery(key) {
+		return provider.LookupJSON(doc, strings.Split(strings.Trim(key, "/"), "/"))
+	}
+	if !strings.HasPrefix(key, "$") {
+		if val, err := provider.LookupJSON(doc, []string{key}); err == nil {
+			return val, nil
+		}
+	}
+	path, err := parseKeyPath(key)
+	if err != nil {
+		return nil, err
+	}
+	return provider.LookupJSON(doc, path)
+}
+
+// Services that take the path in the URL, such as Azure IMDS, get a dot or
+// JSONPath key as a path with slashes.
+func slashKey(key string) string {
+	if !isPathQuery(key) {
+		return key
+	}
+	path, err := parseKeyPath(key)
+	if err != nil {
+		return key
+	}
+	return strings.Join(path, "/")
+}
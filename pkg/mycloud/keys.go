@@ -0,0 +1,191 @@
+package mycloud
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+// A curated list of the keys worth knowing about on each cloud so that the
+// cloud's documentation is not needed to know what to pass to -key.
+//
+//go:embed keys.json
+var embeddedKeyCatalog []byte
+
+type CatalogKey struct {
+	Key         string `json:"key"`
+	Description string `json:"description"`
+}
+
+func keyCatalog() map[string][]CatalogKey {
+	catalog := map[string][]CatalogKey{}
+	if err := json.Unmarshal(embeddedKeyCatalog, &catalog); err != nil {
+		panic("The built in key catalog is invalid: " + err.Error())
+	}
+	return catalog
+}
+
+// Clouds whose metadata service can say which keys it has.  Keys are listed
+// by their full path, with a trailing / on directories so that they can be
+// listed in turn.
+type KeyLister interface {
+	listKeys(ctx context.Context, dir string) ([]string, error)
+}
+
+// The names in a directory listing, one per line.  The public keys on AWS
+// are listed as <index>=<name> and are directories too.
+func listedKeys(ctx context.Context, cd CloudDetector, dir string) ([]string, error) {
+	if dir != "" && !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	listing, err := cd.getKey(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	keys := []string{}
+	for _, line := range strings.Split(*listing, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
+		}
+		if i := strings.Index(name, "="); i > 0 {
+			name = name[:i] + "/"
+		}
+		keys = append(keys, dir+name)
+	}
+	return keys, nil
+}
+
+func runLiveKeys(ctx context.Context, cd CloudDetector) int {
+	lister, ok := keyListerOf(cd)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Listing the keys is not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	keys, err := lister.listKeys(ctx, globalOpts.key)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to list the keys under %s: %s\n", globalOpts.key, err)
+		return keyErrorExitCode(err)
+	}
+	for _, key := range keys {
+		fmt.Printf("%s\n", key)
+	}
+	return foundExitCode
+}
+
+func runKeys(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	if globalOpts.live {
+		cd := detect(ctx, cdList, status)
+		if cd == nil {
+			fmt.Printf("UNKNOWN\n")
+			return detectionFailedExitCode(cdList)
+		}
+		return runLiveKeys(ctx, cd)
+	}
+	catalog := keyCatalog()
+	id := globalOpts.cloud
+	if id == "" {
+		cd := detect(ctx, cdList, status)
+		if cd == nil {
+			fmt.Printf("UNKNOWN\n")
+			return detectionFailedExitCode(cdList)
+		}
+		id = cd.cloudFingerprint().ID
+	}
+	keys, ok := catalog[id]
+	if !ok {
+		ids := make([]string, 0, len(catalog))
+		for id := range catalog {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		fmt.Fprintf(os.Stderr, "There is no key catalog for %s.  Catalogs exist for: %v\n", id, ids)
+		if globalOpts.cloud != "" {
+			return usageExitCode
+		}
+		return errorExitCode
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s\t%s\n", k.Key, k.Description)
+	}
+	w.Flush()
+	return foundExitCode
+}
+
+/////////////////////////////////////////////////////////
+// Directory listings
+/////////////////////////////////////////////////////////
+func (c *AWSCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, dir)
+}
+
+func (c *EC2CompatibleCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, dir)
+}
+
+func (c *EC2CloneCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, dir)
+}
+
+func (c *AlibabaCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, dir)
+}
+
+func (c *CloudStackCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, dir)
+}
+
+func (c *DigitalOceanCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, dir)
+}
+
+// The top level trees are listed from the root rather than from the
+// instance tree that keys default to.
+func (c *GCECloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return listedKeys(ctx, c, gceKeyPath(dir))
+}
+
+/////////////////////////////////////////////////////////
+// JSON documents
+/////////////////////////////////////////////////////////
+func (c *OpenStackCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	if _, err := c.getKey(ctx, "uuid"); err != nil {
+		return nil, err
+	}
+	return provider.DocumentKeys(*c.metadata, dir)
+}
+
+func (c *EquinixCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	if _, err := c.getKey(ctx, "id"); err != nil {
+		return nil, err
+	}
+	return provider.DocumentKeys(*c.metadata, dir)
+}
+
+func (c *AzureCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	doc, _, err := getUrl(ctx, c.fingerprint.BaseUrl+"?api-version="+c.apiVersion, c.fingerprint.Headers)
+	if err != nil {
+		return nil, err
+	}
+	return provider.DocumentKeys(*doc, dir)
+}
+
+func (c *OCICloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	doc, _, err := getUrl(ctx, c.baseUrl, c.headers)
+	if err != nil {
+		return nil, err
+	}
+	return provider.DocumentKeys(*doc, dir)
+}
+
+func (c *ECSCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return provider.ResourceKeys(ctx, c.getKey, []string{"container", "task"}, dir)
+}
@@ -0,0 +1,81 @@
+package mycloud
+
+import (
+	"context"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+var cpuidHypervisors = map[string]string{
+	"KVMKVMKVM":    "KVM",
+	"TCGTCGTCGTCG": "QEMU",
+	"Microsoft Hv": "Hyper-V",
+	"VMwareVMware": "VMware",
+	"XenVMMXenVMM": "Xen",
+	"VBoxVBoxVBox": "VirtualBox",
+	"bhyve bhyve ": "bhyve",
+}
+
+// A variable so that the tests can run on any hypervisor.
+var readCPUIDSignature = cpuidSignature
+
+// The hypervisor named by the CPUID signature, if any.
+func cpuidHypervisor() string {
+	return cpuidHypervisors[strings.TrimRight(readCPUIDSignature(), "\x00")]
+}
+
+// A cloud does not run on a hypervisor it never uses.
+const cpuidDisagreement = -20
+
+// The CPUID signature is a hint of the cloud that is there even where DMI
+// is hidden, such as in containers or for non-root users.  Clouds whose
+// fingerprint lists the hypervisors they run on lose confidence when the
+// signature is another one.  Agreeing adds nothing, since the layers on
+// top of a cloud, such as AWS ECS on EC2, list no hypervisors and must
+// still win the tie with it.  Bare metal and CPUs without CPUID say
+// nothing.
+func cpuidConfidence(fp Fingerprint) int {
+	hypervisor := cpuidHypervisor()
+	if hypervisor == "" || len(fp.Hypervisors) == 0 {
+		return 0
+	}
+	for _, h := range fp.Hypervisors {
+		if strings.EqualFold(h, hypervisor) {
+			return 0
+		}
+	}
+	return cpuidDisagreement
+}
+
+/////////////////////////////////////////////////////////
+// QEMU/KVM
+/////////////////////////////////////////////////////////
+type KVMDetector struct {
+	BaseCloud
+}
+
+// Virtual machines that are not in any cloud we know, such as libvirt
+// hosts in a data center, are still worth telling from bare metal.
+func NewKVMDetector() KVMDetector {
+	c := KVMDetector{}
+	c.fingerprint = fingerprintFor("kvm")
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceLow
+	return c
+}
+
+func (c *KVMDetector) detectEffectiveCloud(ctx context.Context) {
+	hypervisor := cpuidHypervisor()
+	c.signal = signalCPUID
+	switch hypervisor {
+	case "KVM":
+		c.setAttribute("kvm.accelerator", "kvm")
+	case "QEMU":
+		c.setAttribute("kvm.accelerator", "tcg")
+	case "":
+		hypervisor = dmiHypervisor(readDMI())
+		c.signal = signalDMI
+	}
+	c.isMyCloud = hypervisor == "KVM" || hypervisor == "QEMU"
+}
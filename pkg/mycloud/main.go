@@ -0,0 +1,1997 @@
+package mycloud
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+	"github.com/buzztroll/mycloud/pkg/mycloud/providers/ibm"
+	"github.com/buzztroll/mycloud/pkg/mycloud/providers/linode"
+)
+
+type CommandOptions struct {
+	verbose         bool
+	details         bool
+	format          string
+	template        *template.Template
+	key             string
+	keys            []string
+	defaultValue    *string
+	decode          []string
+	part            string
+	vendorData      bool
+	manifest        []ManifestEntry
+	output          string
+	outputMode      os.FileMode
+	outputOwner     string
+	azureApiVersion string
+	iface           string
+	sourceAddr      net.IP
+	statusFile      string
+	probeAllIfaces  bool
+	cloud           string
+	live            bool
+	all             bool
+	audience        string
+	verify          bool
+	scopes          []string
+	resource        string
+	clientId        string
+	secretName      string
+	secretsManager  bool
+	project         string
+	secretVersion   string
+	vault           string
+	vaultAddr       string
+	vaultRole       string
+	vaultMount      string
+	vaultAwsMethod  string
+	vaultNonce      string
+	vaultServerId   string
+	interval        time.Duration
+	waitForCloud    bool
+	breakerFailures int
+	breakerCooldown time.Duration
+	timeout         time.Duration
+	probeTimeout    time.Duration
+	retries         int
+	retryBackoff    time.Duration
+	retryJitter     float64
+	walkRate        float64
+	walkConcurrency int
+	walkMaxKeys     int
+	resume          string
+	hook            string
+	caBundle        string
+}
+
+var globalOpts CommandOptions
+
+// Verbose output goes through redact so that it can be attached to bug
+// reports as it is.
+func logOutput(message string, a ...interface{}) {
+	if !globalOpts.verbose {
+		return
+	}
+	fmt.Fprint(os.Stderr, redact(fmt.Sprintf(message, a...)))
+}
+
+func getUrl(ctx context.Context, url string, headers map[string]string) (*string, *http.Response, error) {
+	return fetchUrl(ctx, "GET", url, headers)
+}
+
+// The errors of a request are shared with the provider packages.
+type ThrottledError = provider.ThrottledError
+type InvalidResponseError = provider.InvalidResponseError
+type HTTPStatusError = provider.HTTPStatusError
+
+// Why a probe failed.  A DNS, connect or TLS failure usually means the
+// environment is misconfigured while an HTTP status means something answered
+// that is not the cloud we were looking for.
+const (
+	ErrorCategoryDNS        = "dns"
+	ErrorCategoryConnect    = "connect"
+	ErrorCategoryTLS        = "tls"
+	ErrorCategoryTimeout    = "timeout"
+	ErrorCategoryHTTPStatus = "http-status"
+	ErrorCategoryThrottled  = "throttled"
+	ErrorCategoryInvalid    = "invalid-response"
+	ErrorCategoryOther      = "other"
+)
+
+// The HTTP status of an HTTPStatusError, or 0 for any other error.
+func statusCodeOf(err error) int {
+	var status *HTTPStatusError
+	if errors.As(err, &status) {
+		return status.StatusCode
+	}
+	return 0
+}
+
+func classifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	var throttled *ThrottledError
+	var status *HTTPStatusError
+	var invalid *InvalidResponseError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	// What the verification of a certificate fails with, which Go 1.20
+	// and later wrap in a tls.CertificateVerificationError.
+	var authorityErr x509.UnknownAuthorityError
+	var invalidCertErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	switch {
+	case errors.As(err, &throttled):
+		return ErrorCategoryThrottled
+	case errors.As(err, &status):
+		return ErrorCategoryHTTPStatus
+	case errors.As(err, &invalid):
+		return ErrorCategoryInvalid
+	case errors.As(err, &dnsErr):
+		return ErrorCategoryDNS
+	case errors.As(err, &recordErr), errors.As(err, &authorityErr), errors.As(err, &invalidCertErr), errors.As(err, &hostnameErr):
+		return ErrorCategoryTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorCategoryTimeout
+	case errors.As(err, &opErr):
+		return ErrorCategoryConnect
+	}
+	return ErrorCategoryOther
+}
+
+// How long a metadata request may take unless WithTimeout says otherwise.
+const metadataTimeout = 1 * time.Second
+
+// Most metadata services are plain http but some, like Equinix Metal, are
+// https.  Private CAs for those can be added with --ca-bundle.
+func newTransport(dialer *net.Dialer, caBundle string) *http.Transport {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caBundle != "" {
+		pem, err := ioutil.ReadFile(caBundle)
+		if err != nil {
+			logOutput("Could not read the CA bundle %s: %s\n", caBundle, err)
+		} else {
+			pool, err := x509.SystemCertPool()
+			if err != nil {
+				pool = x509.NewCertPool()
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				logOutput("No certificates found in the CA bundle %s\n", caBundle)
+			}
+			tlsConfig.RootCAs = pool
+		}
+	}
+	return &http.Transport{
+		Proxy:               http.ProxyFromEnvironment,
+		DialContext:         dialer.DialContext,
+		TLSClientConfig:     tlsConfig,
+		TLSHandshakeTimeout: dialer.Timeout,
+	}
+}
+
+// Connecting may take as long as the longest request may.
+func (s *settings) dialTimeout() time.Duration {
+	timeout := metadataTimeout
+	if s.timeout > timeout {
+		timeout = s.timeout
+	}
+	if s.probeTimeout > timeout {
+		timeout = s.probeTimeout
+	}
+	return timeout
+}
+
+// What tells the transports of different settings apart.
+type transportKey struct {
+	iface       string
+	sourceAddr  string
+	caBundle    string
+	dialTimeout time.Duration
+}
+
+var transportLock sync.Mutex
+var metadataTransports = map[transportKey]http.RoundTripper{}
+
+// The metadata requests of the same settings share one transport, and its
+// connections, which honours the interface binding options.
+func (s *settings) transport() http.RoundTripper {
+	key := transportKey{s.iface, s.sourceAddr.String(), s.caBundle, s.dialTimeout()}
+	transportLock.Lock()
+	defer transportLock.Unlock()
+	if t, ok := metadataTransports[key]; ok {
+		return t
+	}
+	dialer := &net.Dialer{Timeout: key.dialTimeout}
+	if s.iface != "" {
+		if err := bindDialer(dialer, s.iface); err != nil {
+			logOutput("Could not bind to the interface %s: %s\n", s.iface, err)
+		}
+	}
+	if s.sourceAddr != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: s.sourceAddr}
+	}
+	t := newTransport(dialer, s.caBundle)
+	metadataTransports[key] = t
+	return t
+}
+
+// Some bonded and multi-VPC setups only reach the metadata service through
+// a secondary NIC.  With --probe-all-interfaces a request that cannot connect
+// is retried on every interface that is up, and the interface that worked is
+// remembered for later requests to the same host.
+const maxInterfaceProbes = 4
+const interfaceProbeTimeout = 500 * time.Millisecond
+
+var interfaceLock sync.Mutex
+var interfaceTransports = map[transportKey]http.RoundTripper{}
+var hostInterfaces = map[string]string{}
+
+func interfaceTransport(iface string, caBundle string) http.RoundTripper {
+	key := transportKey{iface: iface, caBundle: caBundle}
+	interfaceLock.Lock()
+	defer interfaceLock.Unlock()
+	if t, ok := interfaceTransports[key]; ok {
+		return t
+	}
+	dialer := &net.Dialer{Timeout: interfaceProbeTimeout}
+	if err := bindDialer(dialer, iface); err != nil {
+		logOutput("Could not bind to the interface %s: %s\n", iface, err)
+	}
+	t := newTransport(dialer, caBundle)
+	interfaceTransports[key] = t
+	return t
+}
+
+func hostOf(rawurl string) string {
+	u, err := neturl.Parse(rawurl)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func upInterfaces() []string {
+	var names []string
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return names
+	}
+	for _, ifi := range ifaces {
+		if ifi.Flags&net.FlagUp != 0 && ifi.Flags&net.FlagLoopback == 0 {
+			names = append(names, ifi.Name)
+		}
+	}
+	return names
+}
+
+type interfaceResult struct {
+	iface    string
+	metadata *string
+	resp     *http.Response
+	err      error
+}
+
+func fetchOnAnyInterface(ctx context.Context, method string, url string, headers map[string]string, body string) (*interfaceResult, bool) {
+	ifaces := upInterfaces()
+	results := make(chan interfaceResult, len(ifaces))
+	sem := make(chan struct{}, maxInterfaceProbes)
+	for _, iface := range ifaces {
+		go func(iface string) {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			client := &http.Client{Timeout: interfaceProbeTimeout, Transport: interfaceTransport(iface, settingsOf(ctx).caBundle)}
+			metadata, resp, err := fetchWithClient(ctx, client, method, url, headers, body)
+			results <- interfaceResult{iface, metadata, resp, err}
+		}(iface)
+	}
+	for range ifaces {
+		r := <-results
+		if r.err == nil || provider.IsThrottled(r.err) {
+			logOutput("Reached %s through the interface %s\n", url, r.iface)
+			interfaceLock.Lock()
+			hostInterfaces[hostOf(url)] = r.iface
+			interfaceLock.Unlock()
+			return &r, true
+		}
+	}
+	return nil, false
+}
+
+func fetchUrl(ctx context.Context, method string, url string, headers map[string]string) (*string, *http.Response, error) {
+	return sendUrl(ctx, method, url, headers, "")
+}
+
+// Like fetchUrl but with a request body.
+func sendUrl(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, *http.Response, error) {
+	s := settingsOf(ctx)
+	timeout := metadataTimeout
+	if s.timeout > 0 {
+		timeout = s.timeout
+	}
+	interfaceLock.Lock()
+	iface, found := hostInterfaces[hostOf(url)]
+	interfaceLock.Unlock()
+	client := s.clientFor(timeout, s.transport())
+	if found && s.client == nil {
+		client.Transport = interfaceTransport(iface, s.caBundle)
+	}
+	metadata, resp, err := fetchWithClient(ctx, client, method, url, headers, body)
+	if err != nil && !found && s.client == nil && s.probeAllIfaces && s.iface == "" {
+		category := classifyError(err)
+		if category == ErrorCategoryConnect || category == ErrorCategoryTimeout {
+			if r, ok := fetchOnAnyInterface(ctx, method, url, headers, body); ok {
+				return r.metadata, r.resp, r.err
+			}
+		}
+	}
+	return metadata, resp, err
+}
+
+func fetchWithClient(ctx context.Context, client *http.Client, method string, url string, headers map[string]string, body string) (*string, *http.Response, error) {
+	url = settingsOf(ctx).rewrite(url)
+	policy := settingsOf(ctx).retrying()
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, v := range headers {
+			req.Header.Add(k, v)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			// The caller's own deadline is not the metadata service's
+			// doing.
+			if policy.timeouts && attempt < policy.count && ctx.Err() == nil && classifyError(err) == ErrorCategoryTimeout {
+				wait := policy.wait(nil, attempt)
+				logOutput("Timed out on %s, retrying in %s\n", url, wait)
+				if err := sleepContext(ctx, wait); err != nil {
+					return nil, nil, err
+				}
+				continue
+			}
+			return nil, resp, err
+		}
+		if retryableStatus(resp.StatusCode) {
+			resp.Body.Close()
+			if attempt < policy.count {
+				wait := policy.wait(resp, attempt)
+				logOutput("Got %s from %s, retrying in %s\n", resp.Status, url, wait)
+				if err := sleepContext(ctx, wait); err != nil {
+					return nil, resp, err
+				}
+				continue
+			}
+			if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+				logOutput("Throttled by %s\n", url)
+				return nil, resp, &ThrottledError{URL: url, Status: resp.Status}
+			}
+		}
+		if resp.StatusCode != 200 {
+			resp.Body.Close()
+			return nil, resp, &HTTPStatusError{URL: url, Status: resp.Status, StatusCode: resp.StatusCode}
+		}
+		out, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, resp, err
+		}
+		s := string(out)
+		logOutput("Got %s from %s: %s\n", resp.Status, url, loggedBody(url, s))
+		return &s, resp, err
+	}
+}
+
+/////////////////////////////////////////////////////////
+//  Base Cloud
+/////////////////////////////////////////////////////////
+type BaseCloud struct {
+	name        string
+	isMyCloud   bool
+	supportsKey bool
+	attributes  map[string]string
+	diagnostic  string
+	probeError  error
+	fingerprint Fingerprint
+	// Left at 0 by the clouds that prove themselves, see cloudConfidence.
+	confidence int
+	// Matched only because the metadata service throttled the probe, which
+	// any service at the same address, or a proxy in front of it, may do.
+	throttled bool
+	// What the match rests on, signalHTTP when it is left empty.
+	signal string
+}
+
+func (c *BaseCloud) cloudDescription() string {
+	return c.name
+}
+
+func (c *BaseCloud) cloudAttributes() map[string]string {
+	return c.attributes
+}
+
+// The error of the request that decided the cloud was not this one.
+func (c *BaseCloud) cloudProbeError() error {
+	return c.probeError
+}
+
+// An explanation of why detection failed when the failure looks like a
+// misconfiguration rather than a different cloud.
+func (c *BaseCloud) cloudDiagnostic() string {
+	return c.diagnostic
+}
+
+// How sure the detector is of its match, none when it did not match,
+// moved by the weight of the signal it rests on and by what the CPUID
+// signature says.
+func (c *BaseCloud) cloudConfidence() int {
+	if !c.isMyCloud {
+		return 0
+	}
+	confidence := c.confidence
+	if confidence == 0 {
+		confidence = provider.ConfidenceHigh
+	}
+	if c.throttled {
+		confidence = provider.ConfidenceLow
+	}
+	return confidence + signalWeight(c.signal) + cpuidConfidence(c.fingerprint)
+}
+
+func (c *BaseCloud) cloudFingerprint() Fingerprint {
+	return c.fingerprint
+}
+
+func (c *BaseCloud) setAttribute(name string, value string) {
+	if c.attributes == nil {
+		c.attributes = map[string]string{}
+	}
+	c.attributes[name] = value
+}
+
+func (c *BaseCloud) isEffectiveCloud() bool {
+	return c.isMyCloud
+}
+
+func (c *BaseCloud) supportsKeys() bool {
+	return c.supportsKey
+}
+
+func (c *BaseCloud) getKey(ctx context.Context, key string) (*string, error) {
+	return nil, errors.New("Cloud does not support keys")
+}
+
+/////////////////////////////////////////////////////////
+//  A few clouds base their information of a simple
+//  http get
+/////////////////////////////////////////////////////////
+type SimpleUrlBasedCloud struct {
+	BaseCloud
+	baseUrl  string
+	testUrl  string
+	headers  map[string]string
+	metadata *string
+	// Captive portals and transparent proxies happily answer 200, so the
+	// response to testUrl must also look like it came from the cloud.
+	validate func(string) bool
+}
+
+func (c *SimpleUrlBasedCloud) setFingerprint(fp Fingerprint) {
+	c.fingerprint = fp
+	c.name = fp.Name
+	c.baseUrl = fp.BaseUrl
+	c.testUrl = fp.TestUrl
+	c.headers = fp.Headers
+	if fp.IdPattern != "" {
+		re, err := regexp.Compile(fp.IdPattern)
+		if err != nil {
+			logOutput("Ignoring the id pattern of %s: %s\n", fp.Name, err)
+		} else {
+			c.validate = re.MatchString
+		}
+	}
+}
+
+func (c *SimpleUrlBasedCloud) checkResponse(metadata *string, err error) {
+	if err == nil && c.validate != nil && !c.validate(strings.TrimSpace(*metadata)) {
+		err = &InvalidResponseError{URL: c.testUrl}
+		metadata = nil
+	}
+	c.metadata = metadata
+	c.isMyCloud = err == nil || provider.IsThrottled(err)
+	c.throttled = provider.IsThrottled(err)
+	c.probeError = err
+}
+
+func (c *SimpleUrlBasedCloud) detectEffectiveCloud(ctx context.Context) {
+	metadata, _, err := getUrl(ctx, c.testUrl, c.headers)
+	c.checkResponse(metadata, err)
+}
+
+func (c *SimpleUrlBasedCloud) getKey(ctx context.Context, key string) (*string, error) {
+	url := c.baseUrl + key
+	metadata, _, err := getUrl(ctx, url, c.headers)
+	return metadata, err
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+type AWSCloud struct {
+	SimpleUrlBasedCloud
+	tokenLock *sync.Mutex
+	// When the IMDSv2 token runs out, zero without one.
+	tokenExpires time.Time
+}
+
+func NewAWSCloud() AWSCloud {
+	c := AWSCloud{tokenLock: &sync.Mutex{}}
+	c.setFingerprint(fingerprintFor("aws"))
+	c.supportsKey = true
+	return c
+}
+
+const awsHopLimitDiagnostic = `The AWS metadata service answered but the IMDSv2 token response never arrived.
+This is what happens when IMDSv2 is required and the PUT response hop limit is 1.
+It is reachable from the host but not from a container; increase
+HttpPutResponseHopLimit on the instance (aws ec2 modify-instance-metadata-options
+--http-put-response-hop-limit 2).`
+
+// The partition decides the endpoint domain and the ARN prefix, so it is
+// derived from the region prefix rather than guessed by callers.
+var awsPartitions = []struct {
+	regionPrefix string
+	partition    string
+	domain       string
+}{
+	{"cn-", "aws-cn", "amazonaws.com.cn"},
+	{"us-gov-", "aws-us-gov", "amazonaws.com"},
+	{"us-isob-", "aws-iso-b", "sc2s.sgov.gov"},
+	{"us-iso-", "aws-iso", "c2s.ic.gov"},
+	{"eu-isoe-", "aws-iso-e", "cloud.adc-e.uk"},
+	{"us-isof-", "aws-iso-f", "csp.hci.ic.gov"},
+}
+
+func awsPartition(region string) (string, string) {
+	for _, p := range awsPartitions {
+		if strings.HasPrefix(region, p.regionPrefix) {
+			return p.partition, p.domain
+		}
+	}
+	return "aws", "amazonaws.com"
+}
+
+// Eucalyptus, OpenStack and other clones serve /latest/meta-data/ too, and
+// some of them an identity document as well.  The DMI vendor is the best
+// evidence: every AWS instance with DMI data says Amazon somewhere in it.
+// Without DMI data (Xen PV) a missing identity document means a clone, but
+// when it could not be fetched for another reason (a timeout say) give AWS
+// the benefit of the doubt.
+func isRealAWS(identityErr error, fp Fingerprint) bool {
+	if dmi := readDMI(); len(dmi) > 0 {
+		return dmiMatches(dmi, fp.DMI)
+	}
+	if identityErr == nil {
+		return true
+	}
+	return classifyError(identityErr) != ErrorCategoryHTTPStatus
+}
+
+// How long the IMDSv2 tokens are asked for, the most IMDS allows.
+const awsTokenTTL = 6 * time.Hour
+
+func (c *AWSCloud) fetchToken(ctx context.Context) error {
+	ttl := strconv.Itoa(int(awsTokenTTL / time.Second))
+	token, _, err := fetchUrl(ctx, "PUT", c.fingerprint.Urls["token"], map[string]string{"X-aws-ec2-metadata-token-ttl-seconds": ttl})
+	if err != nil {
+		return err
+	}
+	c.headers = provider.MergeStrings(c.headers, map[string]string{"X-aws-ec2-metadata-token": *token})
+	c.tokenExpires = time.Now().Add(awsTokenTTL)
+	return nil
+}
+
+// The token of detection runs out while watch, or a library caller, is
+// still asking for keys, so it is renewed before it does and when IMDS
+// turns it down.
+func (c *AWSCloud) getKey(ctx context.Context, key string) (*string, error) {
+	metadata, _, err := getUrl(ctx, c.baseUrl+key, c.tokenHeaders(ctx, false))
+	if statusCodeOf(err) == http.StatusUnauthorized {
+		if headers := c.tokenHeaders(ctx, true); headers != nil {
+			metadata, _, err = getUrl(ctx, c.baseUrl+key, headers)
+		}
+	}
+	return metadata, err
+}
+
+// The headers with a token that is good for a while yet, or nil when
+// renew is true and no new token could be had.
+func (c *AWSCloud) tokenHeaders(ctx context.Context, renew bool) map[string]string {
+	c.tokenLock.Lock()
+	defer c.tokenLock.Unlock()
+	if c.tokenExpires.IsZero() {
+		if renew {
+			return nil
+		}
+		return c.headers
+	}
+	if renew || time.Until(c.tokenExpires) < time.Minute {
+		if err := c.fetchToken(ctx); err != nil {
+			logOutput("Could not renew the IMDSv2 token: %s\n", err)
+			if renew {
+				return nil
+			}
+		}
+	}
+	return c.headers
+}
+
+// IMDSv2 wants a session token on every request.  When the token cannot be
+// had the requests are made without one, which works for IMDSv1.
+func (c *AWSCloud) detectEffectiveCloud(ctx context.Context) {
+	tokenErr := c.fetchToken(ctx)
+
+	metadata, resp, err := getUrl(ctx, c.testUrl, c.headers)
+	c.checkResponse(metadata, err)
+	if !c.isMyCloud {
+		if resp != nil && resp.StatusCode == http.StatusUnauthorized && tokenErr != nil && inContainer() {
+			c.diagnostic = awsHopLimitDiagnostic
+		}
+		return
+	}
+	doc, _, err := getUrl(ctx, c.fingerprint.Urls["identity"], c.headers)
+	if !isRealAWS(err, c.fingerprint) {
+		logOutput("The EC2 metadata service answered but this is not AWS\n")
+		c.isMyCloud = false
+		c.probeError = &InvalidResponseError{URL: c.fingerprint.Urls["identity"]}
+		return
+	}
+	if settingsOf(ctx).verify {
+		if err == nil {
+			err = c.verifyIdentity(ctx, *doc)
+		} else {
+			err = &VerificationError{err.Error()}
+		}
+		if err != nil {
+			c.isMyCloud = false
+			c.probeError = err
+			c.diagnostic = err.Error()
+			return
+		}
+		c.setAttribute("aws.verified", "true")
+	}
+	if err != nil {
+		logOutput("Could not get the AWS identity document: %s\n", err)
+		return
+	}
+	var identity struct {
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &identity); err != nil || identity.Region == "" {
+		logOutput("Could not find the region in the AWS identity document\n")
+		return
+	}
+	partition, domain := awsPartition(identity.Region)
+	c.setAttribute("aws.region", identity.Region)
+	c.setAttribute("aws.partition", partition)
+	c.setAttribute("aws.domain", domain)
+	if identity.AvailabilityZone != "" {
+		c.setAttribute("aws.zone-type", awsZoneType(identity.Region, identity.AvailabilityZone))
+	}
+}
+
+// Zones in a region are the region and a letter.  Local Zones add the
+// metro and a number (us-west-2-lax-1a) and Wavelength Zones the carrier's
+// zone (us-east-1-wl1-bos-wlz-1).  Outposts report the zone of their parent
+// region and cannot be told apart here, and any other kind of zone is
+// unknown rather than taken for one of these.
+var (
+	awsLocalZone      = regexp.MustCompile(`^-[a-z]+-[0-9]+[a-z]$`)
+	awsWavelengthZone = regexp.MustCompile(`^-wl[0-9]+-[a-z]+-wlz-[0-9]+$`)
+)
+
+func awsZoneType(region string, zone string) string {
+	if !strings.HasPrefix(zone, region) {
+		return "unknown"
+	}
+	suffix := zone[len(region):]
+	switch {
+	case len(suffix) == 1 && suffix[0] >= 'a' && suffix[0] <= 'z':
+		return "availability-zone"
+	case awsLocalZone.MatchString(suffix):
+		return "local-zone"
+	case awsWavelengthZone.MatchString(suffix):
+		return "wavelength-zone"
+	}
+	return "unknown"
+}
+
+/////////////////////////////////////////////////////////
+// EC2-compatible clouds
+/////////////////////////////////////////////////////////
+type EC2CompatibleCloud struct {
+	SimpleUrlBasedCloud
+}
+
+func NewEC2CompatibleCloud() EC2CompatibleCloud {
+	c := EC2CompatibleCloud{}
+	c.setFingerprint(fingerprintFor("ec2compatible"))
+	c.supportsKey = true
+	c.confidence = provider.ConfidenceMedium
+	return c
+}
+
+// Matches whatever answers like EC2 but is ruled out as AWS, so it is only
+// reported when none of the clouds that also serve an EC2 compatible API
+// matched.
+func (c *EC2CompatibleCloud) detectEffectiveCloud(ctx context.Context) {
+	c.SimpleUrlBasedCloud.detectEffectiveCloud(ctx)
+	if !c.isMyCloud {
+		return
+	}
+	_, _, err := getUrl(ctx, c.fingerprint.Urls["identity"], c.headers)
+	if isRealAWS(err, fingerprintFor("aws")) {
+		c.isMyCloud = false
+		return
+	}
+	if vendor := readDMI()["sys_vendor"]; vendor != "" {
+		c.setAttribute("ec2compatible.vendor", vendor)
+	}
+}
+
+/////////////////////////////////////////////////////////
+// Clouds that serve the EC2 metadata API under their own name
+/////////////////////////////////////////////////////////
+type EC2CloneCloud struct {
+	SimpleUrlBasedCloud
+}
+
+func NewEC2CloneCloud(id string) EC2CloneCloud {
+	c := EC2CloneCloud{}
+	c.setFingerprint(fingerprintFor(id))
+	c.supportsKey = true
+	return c
+}
+
+// An instance id pattern of their own is enough to tell these apart from
+// AWS.  Clouds whose ids look like AWS ids are only claimed on their DMI.
+func (c *EC2CloneCloud) detectEffectiveCloud(ctx context.Context) {
+	c.SimpleUrlBasedCloud.detectEffectiveCloud(ctx)
+	if c.isMyCloud && c.validate == nil {
+		c.isMyCloud = dmiMatches(readDMI(), c.fingerprint.DMI)
+	}
+}
+
+/////////////////////////////////////////////////////////
+// OpenStack
+/////////////////////////////////////////////////////////
+type OpenStackCloud struct {
+	SimpleUrlBasedCloud
+	version string
+}
+
+const openStackDefaultVersion = "2012-08-10"
+
+func NewOpenStackCloud() OpenStackCloud {
+	c := OpenStackCloud{}
+	c.setFingerprint(fingerprintFor("openstack"))
+	c.version = openStackDefaultVersion
+	c.testUrl = c.baseUrl + c.version + "/meta_data.json"
+	c.supportsKey = true
+	c.validate = func(doc string) bool {
+		var m struct {
+			Uuid string `json:"uuid"`
+		}
+		return json.Unmarshal([]byte(doc), &m) == nil && m.Uuid != ""
+	}
+	return c
+}
+
+// The metadata service lists the versions it supports one per line.  Newer
+// versions carry more fields, so prefer latest and then the newest date.
+func openStackVersion(listing string) string {
+	version := ""
+	for _, line := range strings.Split(listing, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "latest" {
+			return line
+		}
+		if line > version {
+			version = line
+		}
+	}
+	return version
+}
+
+func (c *OpenStackCloud) detectEffectiveCloud(ctx context.Context) {
+	listing, _, err := getUrl(ctx, c.baseUrl, c.headers)
+	if err == nil {
+		if version := openStackVersion(*listing); version != "" {
+			c.version = version
+			c.testUrl = c.baseUrl + c.version + "/meta_data.json"
+		}
+	}
+	c.SimpleUrlBasedCloud.detectEffectiveCloud(ctx)
+	if !c.isMyCloud {
+		c.detectConfigDrive()
+	}
+	if c.isMyCloud {
+		c.setAttribute("openstack.metadata-version", c.version)
+	}
+}
+
+// Proxmox config drives look the same but name the instance with a SHA-1
+// rather than a UUID.
+var openStackUuid = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// Without a metadata service, such as on provider networks without a
+// router, the same document is on the config drive.
+func (c *OpenStackCloud) detectConfigDrive() {
+	doc, err := readConfigDrive(c.fingerprint.Files, "openstack/latest/meta_data.json")
+	if err != nil {
+		return
+	}
+	uuid, err := provider.LookupJSON(string(doc), []string{"uuid"})
+	if err != nil || !openStackUuid.MatchString(*uuid) {
+		return
+	}
+	metadata := string(doc)
+	c.metadata = &metadata
+	c.version = "latest"
+	c.isMyCloud = true
+	c.confidence = provider.ConfidenceMedium
+	c.signal = signalFiles
+	c.probeError = nil
+	c.setAttribute("openstack.metadata-source", "config-drive")
+}
+
+func (c *OpenStackCloud) getKey(ctx context.Context, key string) (*string, error) {
+	// Detection may have been throttled before the document was read.
+	if c.metadata == nil {
+		metadata, _, err := getUrl(ctx, c.testUrl, c.headers)
+		if err != nil {
+			return nil, err
+		}
+		c.metadata = metadata
+	}
+
+	// Fields such as devices and meta are not strings so they are
+	// returned as JSON.
+	return lookupKey(*c.metadata, key)
+}
+
+/////////////////////////////////////////////////////////
+// Digital Ocean
+/////////////////////////////////////////////////////////
+type DigitalOceanCloud struct {
+	SimpleUrlBasedCloud
+}
+
+func NewDigitalOceanCloud() DigitalOceanCloud {
+	c := DigitalOceanCloud{}
+	c.setFingerprint(fingerprintFor("digitalocean"))
+	c.supportsKey = true
+	return c
+}
+
+// The tree mirrors the JSON document, so interfaces.public[0].ipv4.address
+// is interfaces/public/0/ipv4/address.
+func (c *DigitalOceanCloud) getKey(ctx context.Context, key string) (*string, error) {
+	return c.SimpleUrlBasedCloud.getKey(ctx, slashKey(key))
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+type GCECloud struct {
+	BaseCloud
+}
+
+func NewGCECloud() GCECloud {
+	c := GCECloud{}
+	c.fingerprint = fingerprintFor("gce")
+	c.supportsKey = true
+	c.name = c.fingerprint.Name
+	return c
+}
+
+func (c *GCECloud) detectEffectiveCloud(ctx context.Context) {
+	c.supportsKey = true
+	_, resp, err := getUrl(ctx, c.fingerprint.TestUrl, c.fingerprint.Headers)
+	c.probeError = err
+
+	if err != nil && !provider.IsThrottled(err) {
+		c.isMyCloud = false
+	} else {
+		c.isMyCloud = resp.Header.Get("Metadata-Flavor") == "Google"
+	}
+	if c.isMyCloud {
+		c.detectServerless()
+	}
+	if c.isMyCloud && c.name == c.fingerprint.Name {
+		c.reportSecurity()
+	}
+}
+
+// Compliance tooling wants to know how the VM is protected.  Only a
+// Shielded VM gets a vTPM on GCE, and Confidential VMs show up as memory
+// encryption flags on the CPU.
+func (c *GCECloud) reportSecurity() {
+	confidential := memoryEncryption()
+	c.setAttribute("gce.confidential-vm", strconv.FormatBool(confidential != ""))
+	if confidential != "" {
+		c.setAttribute("gce.confidential-technology", confidential)
+	}
+	c.setAttribute("gce.shielded-vm", strconv.FormatBool(hasTPM()))
+	c.setAttribute("gce.secure-boot", strconv.FormatBool(secureBootEnabled()))
+}
+
+// Cloud Run and Cloud Functions answer like GCE but run no VM of ours.
+// Knative sets K_SERVICE for both and functions add their entry point.
+func (c *GCECloud) detectServerless() {
+	switch {
+	case os.Getenv("FUNCTION_TARGET") != "" && os.Getenv("K_SERVICE") != "":
+		c.name = fingerprintFor("cloudfunctions").Name
+		c.setAttribute("gcp.service", os.Getenv("K_SERVICE"))
+		c.setAttribute("gcp.function-target", os.Getenv("FUNCTION_TARGET"))
+	case os.Getenv("K_SERVICE") != "":
+		c.name = fingerprintFor("cloudrun").Name
+		c.setAttribute("gcp.service", os.Getenv("K_SERVICE"))
+		c.setAttribute("gcp.revision", os.Getenv("K_REVISION"))
+	case os.Getenv("CLOUD_RUN_JOB") != "":
+		c.name = fingerprintFor("cloudrun").Name
+		c.setAttribute("gcp.job", os.Getenv("CLOUD_RUN_JOB"))
+		c.setAttribute("gcp.execution", os.Getenv("CLOUD_RUN_EXECUTION"))
+	}
+}
+
+// The top level directories of the metadata server.
+var gceTrees = map[string]bool{"instance": true, "project": true, "oslogin": true, "universe": true}
+
+// Keys live under one of the top level trees, mostly instance/ or
+// project/.  A key that does not start with one of them is looked up in
+// the instance tree, and the root is left as it is.
+func gceKeyPath(key string) string {
+	key = strings.TrimPrefix(key, "/")
+	if key == "" || gceTrees[strings.SplitN(key, "/", 2)[0]] {
+		return key
+	}
+	return "instance/" + key
+}
+
+func (c *GCECloud) getKey(ctx context.Context, key string) (*string, error) {
+	url := c.fingerprint.BaseUrl + gceKeyPath(key)
+	metadata, _, err := getUrl(ctx, url, c.fingerprint.Headers)
+	return metadata, err
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+const azureApiVersion = "2021-02-01"
+
+// The sovereign clouds use different management and login endpoints, and
+// tokens must be requested for the audience of the environment the VM is in.
+type AzureEnvironment struct {
+	name                    string
+	resourceManagerEndpoint string
+	activeDirectoryEndpoint string
+}
+
+var azureEnvironments = map[string]AzureEnvironment{
+	"AzurePublicCloud": {
+		"AzurePublicCloud",
+		"https://management.azure.com/",
+		"https://login.microsoftonline.com/"},
+	"AzureChinaCloud": {
+		"AzureChinaCloud",
+		"https://management.chinacloudapi.cn/",
+		"https://login.chinacloudapi.cn/"},
+	"AzureUSGovernmentCloud": {
+		"AzureUSGovernmentCloud",
+		"https://management.usgovcloudapi.net/",
+		"https://login.microsoftonline.us/"},
+	"AzureGermanCloud": {
+		"AzureGermanCloud",
+		"https://management.microsoftazure.de/",
+		"https://login.microsoftonline.de/"},
+	// Every Azure Stack Hub has endpoints of its own, under the operator's
+	// domain, which IMDS does not tell us.
+	"AzureStack": {
+		"AzureStack",
+		"",
+		""},
+}
+
+type AzureCloud struct {
+	BaseCloud
+	environment AzureEnvironment
+	apiVersion  string
+}
+
+func NewAzureCloud() AzureCloud {
+	c := AzureCloud{}
+	c.fingerprint = fingerprintFor("azure")
+	c.name = c.fingerprint.Name
+	c.supportsKey = true
+	c.apiVersion = azureApiVersion
+	return c
+}
+
+// IMDS answers anyone, the agent's ovf-env.xml is only readable by root and
+// is kept as a fallback for hosts where IMDS is blocked.
+func (c *AzureCloud) detectEffectiveCloud(ctx context.Context) {
+	c.negotiateApiVersion(ctx)
+	url := c.fingerprint.BaseUrl + "?api-version=" + c.apiVersion
+	doc, _, err := getUrl(ctx, url, c.fingerprint.Headers)
+	if err == nil {
+		var instance struct {
+			Compute struct {
+				VmId string `json:"vmId"`
+			} `json:"compute"`
+		}
+		if json.Unmarshal([]byte(*doc), &instance) != nil || instance.Compute.VmId == "" {
+			err = &InvalidResponseError{URL: url}
+		}
+	}
+	c.isMyCloud = err == nil || provider.IsThrottled(err)
+	c.throttled = provider.IsThrottled(err)
+	c.probeError = err
+
+	for _, path := range c.fingerprint.Files {
+		if _, err := os.Stat(path); err == nil && (!c.isMyCloud || c.throttled) {
+			c.isMyCloud = true
+			c.throttled = false
+			c.signal = signalFiles
+		}
+	}
+	if c.isMyCloud {
+		c.setAttribute("azure.api-version", c.apiVersion)
+		c.detectEnvironment(ctx)
+		c.detectScaleSet(ctx)
+	}
+}
+
+// Keys are paths into the instance document, e.g. compute/vmSize.  Leaves
+// are fetched as text, anything else comes back as JSON.
+func (c *AzureCloud) getKey(ctx context.Context, key string) (*string, error) {
+	url := c.fingerprint.BaseUrl + strings.Trim(slashKey(key), "/") + "?api-version=" + c.apiVersion
+	metadata, _, err := getUrl(ctx, url+"&format=text", c.fingerprint.Headers)
+	if err != nil && classifyError(err) == ErrorCategoryHTTPStatus {
+		metadata, _, err = getUrl(ctx, url, c.fingerprint.Headers)
+	}
+	return metadata, err
+}
+
+type azureCompute struct {
+	Name             string `json:"name"`
+	VmScaleSetName   string `json:"vmScaleSetName"`
+	PlacementGroupId string `json:"placementGroupId"`
+	OsProfile        struct {
+		ComputerName string `json:"computerName"`
+	} `json:"osProfile"`
+}
+
+// Uniform scale sets name instances <vmss>_<ordinal> and their computer
+// names <prefix><6 base 36 digits of the ordinal>.  Flexible scale sets use
+// a random suffix instead so no ordinal can be derived for them.
+func azureOrdinal(compute azureCompute) (int64, bool) {
+	if i := strings.LastIndex(compute.Name, "_"); i >= 0 {
+		if n, err := strconv.ParseInt(compute.Name[i+1:], 10, 64); err == nil {
+			return n, true
+		}
+	}
+	computerName := compute.OsProfile.ComputerName
+	if len(computerName) > 6 && strings.HasPrefix(compute.Name, computerName[:len(computerName)-6]) {
+		if n, err := strconv.ParseInt(computerName[len(computerName)-6:], 36, 64); err == nil {
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+func (c *AzureCloud) detectScaleSet(ctx context.Context) {
+	url := c.fingerprint.BaseUrl + "compute?api-version=" + c.apiVersion
+	doc, _, err := getUrl(ctx, url, c.fingerprint.Headers)
+	if err != nil {
+		logOutput("Could not get the Azure compute metadata: %s\n", err)
+		return
+	}
+	var compute azureCompute
+	if err := json.Unmarshal([]byte(*doc), &compute); err != nil {
+		logOutput("Could not parse the Azure compute metadata: %s\n", err)
+		return
+	}
+	// Both are empty strings rather than missing outside of scale sets and
+	// for single placement group scale sets.
+	if compute.VmScaleSetName == "" {
+		return
+	}
+	c.setAttribute("azure.vmss-name", compute.VmScaleSetName)
+	if compute.PlacementGroupId != "" {
+		c.setAttribute("azure.placement-group-id", compute.PlacementGroupId)
+	}
+	if ordinal, ok := azureOrdinal(compute); ok {
+		c.setAttribute("azure.vmss-ordinal", strconv.FormatInt(ordinal, 10))
+	}
+}
+
+// Azure retires old api-versions and Azure Stack supports a different set,
+// so use the one we were written against only if IMDS still offers it and
+// otherwise the newest one it does offer.
+func (c *AzureCloud) negotiateApiVersion(ctx context.Context) {
+	c.apiVersion = azureApiVersion
+	if version := settingsOf(ctx).azureApiVersion; version != "" {
+		c.apiVersion = version
+		return
+	}
+	doc, _, err := getUrl(ctx, c.fingerprint.Urls["versions"], c.fingerprint.Headers)
+	if err != nil {
+		logOutput("Could not list the Azure IMDS api versions: %s\n", err)
+		return
+	}
+	var versions struct {
+		ApiVersions []string `json:"apiVersions"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &versions); err != nil || len(versions.ApiVersions) == 0 {
+		logOutput("Could not parse the Azure IMDS api versions\n")
+		return
+	}
+	sort.Strings(versions.ApiVersions)
+	for _, v := range versions.ApiVersions {
+		if v == azureApiVersion {
+			return
+		}
+	}
+	c.apiVersion = versions.ApiVersions[len(versions.ApiVersions)-1]
+}
+
+// Ask IMDS which Azure environment we are in.  Older hosts do not know
+// about azEnvironment so fall back to the public cloud.
+func (c *AzureCloud) detectEnvironment(ctx context.Context) {
+	c.environment = azureEnvironments["AzurePublicCloud"]
+	url := c.fingerprint.BaseUrl + "compute/azEnvironment?api-version=" + c.apiVersion + "&format=text"
+	name, _, err := getUrl(ctx, url, c.fingerprint.Headers)
+	if err != nil {
+		logOutput("Could not determine the Azure environment: %s\n", err)
+	} else {
+		env, ok := azureEnvironments[strings.TrimSpace(*name)]
+		if ok {
+			c.environment = env
+		} else {
+			logOutput("Unknown Azure environment %s\n", *name)
+		}
+	}
+	c.setAttribute("azure.environment", c.environment.name)
+	if c.environment.name == "AzureStack" {
+		c.name = fingerprintFor("azurestack").Name
+		return
+	}
+	c.setAttribute("azure.resource-manager", c.environment.resourceManagerEndpoint)
+	c.setAttribute("azure.active-directory", c.environment.activeDirectoryEndpoint)
+}
+
+/////////////////////////////////////////////////////////
+// Joyent
+/////////////////////////////////////////////////////////
+type JoyentCloud struct {
+	BaseCloud
+	mdata    *mdataClient
+	mdataGet string
+}
+
+func NewJoyentCloud() JoyentCloud {
+	c := JoyentCloud{}
+	c.fingerprint = fingerprintFor("joyent")
+	c.supportsKey = true
+	c.name = c.fingerprint.Name
+	return c
+}
+
+// The metadata protocol is spoken directly over the zone's socket or the
+// guest's serial port, so mdata-get is only needed where neither can be
+// opened.  Every machine has a second serial port, so it is only tried when
+// the DMI data says this is a Joyent guest.
+func (c *JoyentCloud) detectEffectiveCloud(ctx context.Context) {
+	c.supportsKey = true
+
+	c.isMyCloud = false
+	joyentHardware := dmiMatches(readDMI(), c.fingerprint.DMI)
+	for _, path := range c.fingerprint.Files {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if info.Mode()&(os.ModeSocket|os.ModeCharDevice) == 0 {
+			if c.mdataGet == "" {
+				c.mdataGet = path
+			}
+			continue
+		}
+		if info.Mode()&os.ModeCharDevice != 0 && !joyentHardware {
+			continue
+		}
+		mdata, err := dialMetadata(path)
+		if err != nil {
+			logOutput("Could not talk to the metadata agent on %s: %s\n", path, err)
+			c.probeError = err
+			continue
+		}
+		c.mdata = mdata
+		c.probeError = nil
+		break
+	}
+	c.isMyCloud = c.mdata != nil || c.mdataGet != ""
+	c.signal = signalFiles
+}
+
+func (c *JoyentCloud) getKey(ctx context.Context, key string) (*string, error) {
+	if c.mdata != nil {
+		return c.mdata.get(key)
+	}
+	out, err := exec.Command(c.mdataGet, key).Output()
+	if err != nil {
+		return nil, err
+	}
+	s := string(out)
+	return &s, nil
+}
+
+///////
+
+func detectEffectiveCloud(ctx context.Context, finished chan<- int, i int, cd CloudDetector, elapsed *time.Duration) {
+	start := time.Now()
+	cd.detectEffectiveCloud(ctx)
+	*elapsed = time.Since(start)
+	finished <- i
+}
+
+type CloudDetector interface {
+	detectEffectiveCloud(context.Context)
+	isEffectiveCloud() bool
+	supportsKeys() bool
+	cloudDescription() string
+	cloudAttributes() map[string]string
+	cloudDiagnostic() string
+	cloudProbeError() error
+	cloudConfidence() int
+	cloudFingerprint() Fingerprint
+	setAttribute(string, string)
+	getKey(context.Context, string) (*string, error)
+}
+
+/////////////////////////////////////////////////////////
+//  Clouds that only exist in fingerprint files
+/////////////////////////////////////////////////////////
+type FingerprintCloud struct {
+	SimpleUrlBasedCloud
+}
+
+func NewFingerprintCloud(fp Fingerprint) FingerprintCloud {
+	c := FingerprintCloud{}
+	c.setFingerprint(fp)
+	c.supportsKey = c.baseUrl != ""
+	c.confidence = provider.ConfidenceMedium
+	return c
+}
+
+var builtinClouds = map[string]bool{
+	"ecs":            true,
+	"fargate":        true,
+	"aws":            true,
+	"gce":            true,
+	"cloudrun":       true,
+	"cloudfunctions": true,
+	"azure":          true,
+	"aci":            true,
+	"azurestack":     true,
+	"openstack":      true,
+	"digitalocean":   true,
+	"joyent":         true,
+	"oci":            true,
+	"ibm":            true,
+	"linode":         true,
+	"equinix":        true,
+	"alibaba":        true,
+	"cloudstack":     true,
+	"brightbox":      true,
+	"outscale":       true,
+	"ec2compatible":  true,
+	"proxmox":        true,
+	"nocloud":        true,
+	"vsphere":        true,
+	"hyperv":         true,
+	"virtualbox":     true,
+	"vagrant":        true,
+	"kvm":            true,
+	"xen":            true,
+	"baremetal":      true,
+}
+
+// The built in clouds, in the order they are tried.  The more specific
+// ones come first, e.g. ECS before AWS and the EC2 clones before anything
+// else that answers at 169.254.169.254.
+func init() {
+	register("ecs", false, func() CloudDetector { c := NewECSCloud(); return &c })
+	register("aws", false, func() CloudDetector { c := NewAWSCloud(); return &c })
+	register("gce", false, func() CloudDetector { c := NewGCECloud(); return &c })
+	register("aci", false, func() CloudDetector { c := NewACICloud(); return &c })
+	register("azure", false, func() CloudDetector { c := NewAzureCloud(); return &c })
+	register("openstack", false, func() CloudDetector { c := NewOpenStackCloud(); return &c })
+	register("digitalocean", false, func() CloudDetector { c := NewDigitalOceanCloud(); return &c })
+	register("joyent", false, func() CloudDetector { c := NewJoyentCloud(); return &c })
+	register("oci", false, func() CloudDetector { c := NewOCICloud(); return &c })
+	registerProvider("ibm", func(fp provider.Fingerprint, client provider.Client) Detector { return ibm.New(fp, client) })
+	registerProvider("linode", func(fp provider.Fingerprint, client provider.Client) Detector { return linode.New(fp, client) })
+	register("equinix", false, func() CloudDetector { c := NewEquinixCloud(); return &c })
+	register("alibaba", false, func() CloudDetector { c := NewAlibabaCloud(); return &c })
+	register("cloudstack", false, func() CloudDetector { c := NewCloudStackCloud(); return &c })
+	register("brightbox", false, func() CloudDetector { c := NewEC2CloneCloud("brightbox"); return &c })
+	register("outscale", false, func() CloudDetector { c := NewEC2CloneCloud("outscale"); return &c })
+	register("ec2compatible", false, func() CloudDetector { c := NewEC2CompatibleCloud(); return &c })
+	register("proxmox", false, func() CloudDetector { c := NewProxmoxCloud(); return &c })
+	register("nocloud", false, func() CloudDetector { c := NewNoCloudDetector(); return &c })
+	register("vsphere", true, func() CloudDetector { c := NewVSphereCloud(); return &c })
+	register("hyperv", true, func() CloudDetector { c := NewHyperVCloud(); return &c })
+	register("virtualbox", true, func() CloudDetector { c := NewVirtualBoxDetector(); return &c })
+	register("kvm", true, func() CloudDetector { c := NewKVMDetector(); return &c })
+	register("xen", true, func() CloudDetector { c := NewXenDetector(); return &c })
+	register("baremetal", true, func() CloudDetector { c := NewBareMetalDetector(); return &c })
+}
+
+func setupClouds() []CloudDetector {
+	cdList := registeredClouds()
+	for _, id := range sortedFingerprintIds() {
+		fp := loadedFingerprints().Clouds[id]
+		if builtinClouds[id] || fp.Name == "" || fp.TestUrl == "" {
+			continue
+		}
+		fp.ID = id
+		fpCloud := NewFingerprintCloud(fp)
+		cdList = append(cdList, &fpCloud)
+	}
+	return cdList
+}
+
+// Parse the command line into globalOpts.  When it is not usable, what is
+// wrong has been printed and ok is false with the exit code to use.
+func setupOptions(cdList []CloudDetector, args []string) (rc int, ok bool) {
+	usageMessage := `Usage: mycloud [command] [options]
+----------------------------------
+This program will inspect the local system to determine what cloud it is running
+in.  If no cloud can be determined it will return 2 (4 when the metadata
+service of a likely cloud could not be reached) and print UNKNOWN to stdout.
+If a cloud is found it will return 0 (6 for bare metal) and print one of the
+following values to stdout:
+`
+	for _, cd := range cdList {
+		usageMessage = usageMessage + "\t" + cd.cloudDescription() + "\n"
+	}
+
+	usageMessage = usageMessage + `
+Optionally this can be used to fetch keys from the clouds metadata server on the
+clouds that support it.  The following clouds support fetching specific metadata
+keys:
+`
+	for _, cd := range cdList {
+		if cd.supportsKeys() {
+			usageMessage = usageMessage + "\t" + cd.cloudDescription() + "\n"
+		}
+	}
+
+	usageMessage = usageMessage + `
+The following commands are available:
+`
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		usageMessage = usageMessage + "\t" + name + "\t" + commands[name].description + "\n"
+	}
+
+	usageMessage = usageMessage + `
+
+[options]
+`
+	flags := flag.NewFlagSet("mycloud", flag.ContinueOnError)
+	var keys keyList
+	flags.Var(&keys, "key", "A metadata key to fetch, repeated or comma separated for several.  This is not supported on all clouds")
+	var verbose = flags.Bool("verbose", false, "Log output to stderr as the program progresses")
+	var details = flags.Bool("details", false, "Print additional attributes of the cloud as name=value lines")
+	var format = flags.String("format", "text", "The output format, text, json, env or raw")
+	flags.StringVar(format, "o", "text", "Short for -format")
+	var raw = flags.Bool("raw", false, "Write only the bytes of the key's value to stdout, short for -format raw")
+	var tmpl = flags.String("template", "", "A Go text/template to print instead of -format, e.g. '{{.Cloud}}:{{.Region}}'")
+	var cloud = flags.String("cloud", "", "The cloud (aws, gce, openstack, ...) to list keys for instead of the detected one")
+	var defaultValue = flags.String("default", "", "The value to print, and exit 0, when a key is missing")
+	var decode = flags.String("decode", "", "Decode key values before printing them: base64, gzip or both in order, e.g. base64,gzip")
+	var part = flags.String("part", "", "The part of a MIME user data archive the user-data command prints, by file name or content type")
+	var vendorData = flags.Bool("vendor-data", false, "Have the user-data command print the vendor data the cloud provides instead")
+	var output = flags.String("output", "", "Write what would go to stdout to this file, atomically and only when the run succeeds")
+	var outputMode = flags.String("output-mode", "0644", "The octal mode of the -output file")
+	var outputOwner = flags.String("output-owner", "", "The user[:group] to own the -output file")
+	var manifest = flags.String("manifest", "", "A JSON or YAML file of keys and the files to write their values to")
+	var live = flags.Bool("live", false, "Have the keys command list the keys the metadata service has under -key instead of the catalog")
+	var all = flags.Bool("all", false, "Print the verdict of every detector, matched, not matched or error, instead of only the cloud found")
+	var verify = flags.Bool("verify", false, "On AWS, check the signature of the identity document and fail detection when it is not AWS's")
+	var scopes = flags.String("scopes", "", "Comma separated OAuth2 scopes of the GCE access token the creds command fetches")
+	var resource = flags.String("resource", "", "The resource the Azure managed identity token the creds command fetches is for")
+	var clientId = flags.String("client-id", "", "The client id of the Azure user assigned managed identity to fetch a token for")
+	var secretName = flags.String("name", "", "The name of the secret the secret command prints")
+	var secretsManager = flags.Bool("secrets-manager", false, "Have the secret command read AWS Secrets Manager rather than the SSM Parameter Store")
+	var project = flags.String("project", "", "The GCP project of the secret the secret command prints, by default the instance's")
+	var secretVersion = flags.String("secret-version", "latest", "The version of the GCP or Azure secret the secret command prints")
+	var vault = flags.String("vault", "", "The URL of the Azure Key Vault the secret command reads, e.g. https://myvault.vault.azure.net")
+	var vaultAddr = flags.String("vault-addr", os.Getenv("VAULT_ADDR"), "The address of the HashiCorp Vault vault-login logs in to")
+	var vaultRole = flags.String("vault-role", "", "The Vault role vault-login logs in as")
+	var vaultMount = flags.String("vault-mount", "", "The path the Vault auth method is mounted at, by default aws, gcp or azure")
+	var vaultAwsMethod = flags.String("vault-aws-method", "iam", "The Vault aws auth method type to log in with on AWS: iam or ec2")
+	var vaultNonce = flags.String("vault-nonce", "", "The nonce Vault returned on the first ec2 login")
+	var vaultServerId = flags.String("vault-server-id", "", "The X-Vault-AWS-IAM-Server-ID header Vault's iam login requires")
+	var audience = flags.String("audience", "", "The audience of the GCE identity token the identity command fetches")
+	var timeout = flags.Duration("timeout", metadataTimeout, "How long a metadata request may take, raise it where the metadata service is slow right after boot")
+	var probeTimeout = flags.Duration("probe-timeout", 0, "How long a metadata request may take while the clouds are probed, -timeout by default")
+	var retries = flags.Int("retries", defaultRetryPolicy.count, "How often a metadata request is retried when it times out or the service answers 429 or 5xx, 0 to not retry")
+	var retryBackoff = flags.Duration("retry-backoff", defaultRetryPolicy.backoff, "How long to wait before the first retry, the wait doubles with each one")
+	var retryJitter = flags.Float64("retry-jitter", defaultRetryPolicy.jitter, "The fraction of each wait between retries that is random, from 0 to 1")
+	var walkRate = flags.Float64("walk-rate", defaultWalkPolicy.rate, "How many requests a second dump, and -key on a directory, send on clouds that need one per key, 0 for no limit")
+	var walkConcurrency = flags.Int("walk-concurrency", defaultWalkPolicy.concurrency, "How many of those requests are sent at once")
+	var walkMaxKeys = flags.Int("walk-max-keys", defaultWalkPolicy.maxKeys, "How many of those requests one run may send, 0 for no limit")
+	var resume = flags.String("resume", "", "A file to record the keys a dump has fetched in, so that a dump that failed or reached -walk-max-keys carries on from it when run again")
+	var interval = flags.Duration("interval", 5*time.Second, "How often the watch command polls the key")
+	var waitForCloud = flags.Bool("wait-for-cloud", false, "Have the watch command detect again every -interval until a cloud is found, instead of exiting")
+	var breakerFailures = flags.Int("breaker-failures", 3, "How many probes of a cloud in a row may fail before -wait-for-cloud stops probing it for -breaker-cooldown, 0 to always probe it")
+	var breakerCooldown = flags.Duration("breaker-cooldown", time.Minute, "How long -wait-for-cloud leaves a failing cloud before probing it once more")
+	var hook = flags.String("exec", "", "A command the watch command runs through the shell when the key changes")
+	var redactPatterns = flags.String("redact", "", "Comma separated regular expressions of more key names whose values -verbose does not log")
+	var caBundle = flags.String("ca-bundle", "", "A PEM file of extra CA certificates for https metadata services")
+	var statusFile = flags.String("status-file", "", "Atomically write a JSON summary of the run to this file")
+	var iface = flags.String("interface", "", "The network interface to send metadata requests from")
+	var probeAllIfaces = flags.Bool("probe-all-interfaces", false, "Retry metadata requests that cannot connect on every interface that is up")
+	var sourceAddr = flags.String("source-address", "", "The local IP address to send metadata requests from")
+	var azureApiVersion = flags.String("azure-api-version", "", "The Azure IMDS api-version to use instead of negotiating one")
+
+	flags.Usage = func() {
+		fmt.Fprint(os.Stderr, usageMessage)
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return foundExitCode, false
+		}
+		return usageExitCode, false
+	}
+
+	globalOpts = CommandOptions{
+		keys:            keys,
+		part:            *part,
+		vendorData:      *vendorData,
+		output:          *output,
+		outputOwner:     *outputOwner,
+		verbose:         *verbose,
+		details:         *details,
+		format:          *format,
+		azureApiVersion: *azureApiVersion,
+		iface:           *iface,
+		statusFile:      *statusFile,
+		probeAllIfaces:  *probeAllIfaces,
+		cloud:           *cloud,
+		live:            *live,
+		all:             *all,
+		audience:        *audience,
+		verify:          *verify,
+		resource:        *resource,
+		clientId:        *clientId,
+		secretName:      *secretName,
+		secretsManager:  *secretsManager,
+		project:         *project,
+		secretVersion:   *secretVersion,
+		vault:           *vault,
+		vaultAddr:       *vaultAddr,
+		vaultRole:       *vaultRole,
+		vaultMount:      *vaultMount,
+		vaultAwsMethod:  *vaultAwsMethod,
+		vaultNonce:      *vaultNonce,
+		vaultServerId:   *vaultServerId,
+		interval:        *interval,
+		waitForCloud:    *waitForCloud,
+		breakerFailures: *breakerFailures,
+		breakerCooldown: *breakerCooldown,
+		timeout:         *timeout,
+		probeTimeout:    *probeTimeout,
+		retries:         *retries,
+		retryBackoff:    *retryBackoff,
+		retryJitter:     *retryJitter,
+		walkRate:        *walkRate,
+		walkConcurrency: *walkConcurrency,
+		walkMaxKeys:     *walkMaxKeys,
+		resume:          *resume,
+		hook:            *hook,
+		caBundle:        *caBundle}
+
+	if *timeout <= 0 || *probeTimeout < 0 {
+		fmt.Fprintf(os.Stderr, "The timeouts must be positive\n")
+		return usageExitCode, false
+	}
+	if *interval <= 0 {
+		fmt.Fprintf(os.Stderr, "The interval must be positive\n")
+		return usageExitCode, false
+	}
+	if *breakerFailures < 0 || *breakerCooldown < 0 {
+		fmt.Fprintf(os.Stderr, "-breaker-failures and -breaker-cooldown must not be negative\n")
+		return usageExitCode, false
+	}
+	if *retries < 0 || *retryBackoff < 0 || *retryJitter < 0 || *retryJitter > 1 {
+		fmt.Fprintf(os.Stderr, "The retries and backoff must not be negative and the jitter must be from 0 to 1\n")
+		return usageExitCode, false
+	}
+	if *walkRate < 0 || *walkConcurrency < 1 || *walkMaxKeys < 0 {
+		fmt.Fprintf(os.Stderr, "The walk rate and -walk-max-keys must not be negative and -walk-concurrency must be at least 1\n")
+		return usageExitCode, false
+	}
+	if len(keys) > 0 {
+		globalOpts.key = keys[0]
+	}
+	if *scopes != "" {
+		globalOpts.scopes = strings.Split(*scopes, ",")
+	}
+	// Set on every run, so that the patterns of an earlier Main do not
+	// stay.
+	var extraRedacted []string
+	if *redactPatterns != "" {
+		extraRedacted = strings.Split(*redactPatterns, ",")
+	}
+	if err := setRedactPatterns(extraRedacted); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -redact pattern: %s\n", err)
+		return usageExitCode, false
+	}
+	if *decode != "" {
+		steps, err := parseDecoders(*decode)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			return usageExitCode, false
+		}
+		globalOpts.decode = steps
+	}
+	// An empty default is a default too, so it is only used when given.
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == "default" {
+			globalOpts.defaultValue = defaultValue
+		}
+	})
+	mode, err := strconv.ParseUint(*outputMode, 8, 32)
+	if err != nil || mode > 0777 {
+		fmt.Fprintf(os.Stderr, "Invalid output mode %s\n", *outputMode)
+		return usageExitCode, false
+	}
+	globalOpts.outputMode = os.FileMode(mode)
+	if *manifest != "" {
+		entries, err := readManifest(*manifest)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid manifest %s: %s\n", *manifest, err)
+			return usageExitCode, false
+		}
+		globalOpts.manifest = entries
+	}
+
+	if *sourceAddr != "" {
+		globalOpts.sourceAddr = net.ParseIP(*sourceAddr)
+		if globalOpts.sourceAddr == nil {
+			fmt.Fprintf(os.Stderr, "Invalid source address %s\n", *sourceAddr)
+			return usageExitCode, false
+		}
+	}
+	if globalOpts.iface != "" {
+		if _, err := net.InterfaceByName(globalOpts.iface); err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid interface %s: %s\n", globalOpts.iface, err)
+			return usageExitCode, false
+		}
+	}
+	if *raw {
+		globalOpts.format = "raw"
+	}
+	if globalOpts.format == "raw" && len(keys) != 1 {
+		fmt.Fprintf(os.Stderr, "The raw format needs exactly one -key\n")
+		return usageExitCode, false
+	}
+	if _, ok := resultWriters[globalOpts.format]; !ok {
+		fmt.Fprintf(os.Stderr, "Unknown output format %s\n", globalOpts.format)
+		return usageExitCode, false
+	}
+	if *tmpl != "" {
+		t, err := parseTemplate(*tmpl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Invalid template: %s\n", err)
+			return usageExitCode, false
+		}
+		globalOpts.template = t
+	}
+	return foundExitCode, true
+}
+
+// Probe the clouds and return the one that matched, or nil after
+// explaining any diagnosed failures.
+func detect(ctx context.Context, cdList []CloudDetector, status *RunStatus) CloudDetector {
+	cd, err := detectCloud(ctx, cdList, status)
+	if err != nil {
+		for _, diagnostic := range err.Diagnostics {
+			fmt.Fprintf(os.Stderr, "%s\n", diagnostic)
+		}
+		return nil
+	}
+	return cd
+}
+
+func detectCloud(ctx context.Context, cdList []CloudDetector, status *RunStatus) (CloudDetector, *DetectionError) {
+	probing := settingsOf(ctx).probing()
+	durations := make([]time.Duration, len(cdList))
+	skipped := make([]bool, len(cdList))
+	dmi := readDMI()
+	// -all is for finding out why detection went wrong, which the DMI
+	// data may be the cause of.
+	if !probing.everyProbe {
+		skipped = ruledOutByDMI(dmi, cdList)
+	}
+	expected := namedByDMI(dmi, cdList)
+	// The cloud the DMI data names is probed whatever its past.
+	broken := make([]bool, len(cdList))
+	if probing.breaker != nil && !probing.everyProbe {
+		now := time.Now()
+		for i, cd := range cdList {
+			if !skipped[i] && !expected[cd.cloudFingerprint().ID] && !probing.breaker.allow(cd.cloudFingerprint().ID, now) {
+				skipped[i] = true
+				broken[i] = true
+			}
+		}
+	}
+	if hypervisor := cpuidHypervisor(); hypervisor != "" {
+		logOutput("The CPUID hypervisor signature is %s\n", hypervisor)
+	}
+	probeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	finished := make(chan int, len(cdList))
+	pending := 0
+	for i, cd := range cdList {
+		if broken[i] {
+			logOutput("Not probing %s, its last probes failed\n", cd.cloudDescription())
+			continue
+		}
+		if skipped[i] {
+			logOutput("Not probing %s, the DMI data is another cloud's\n", cd.cloudDescription())
+			continue
+		}
+		logOutput("Cloud candidate %s\n", cd.cloudDescription())
+		cdCtx := withSettings(probeCtx, probing)
+		if expected[cd.cloudFingerprint().ID] {
+			cdCtx = withSettings(probeCtx, probing.expected())
+		}
+		pending++
+		go detectEffectiveCloud(cdCtx, finished, i, cd, &durations[i])
+	}
+	// The probes of the clouds that are not there take the longest, so
+	// they are stopped once the cloud is known.
+	done := append([]bool{}, skipped...)
+	var confirmed CloudDetector
+	for ; pending > 0; pending-- {
+		done[<-finished] = true
+		if confirmed == nil && !probing.everyProbe {
+			if confirmed = confirmedCloud(cdList, done); confirmed != nil {
+				logOutput("%s is confirmed, stopping the other probes\n", confirmed.cloudDescription())
+				cancel()
+			}
+		}
+	}
+	for i, cd := range cdList {
+		stopped := confirmed != nil && errors.Is(cd.cloudProbeError(), context.Canceled)
+		if stopped {
+			logOutput("Stopped probing %s\n", cd.cloudDescription())
+		} else if err := cd.cloudProbeError(); err != nil {
+			logOutput("Probe for %s failed (%s): %s\n", cd.cloudDescription(), classifyError(err), err)
+		}
+		if probing.breaker != nil && !skipped[i] && !stopped {
+			probing.breaker.record(cd.cloudFingerprint().ID, cd.isEffectiveCloud(), time.Now())
+		}
+		status.addProbe(cd, durations[i], skipped[i], stopped)
+	}
+
+	// A forged identity document must not leave the machine reported as
+	// whatever else it looks like.
+	for _, cd := range cdList {
+		if v, ok := cd.(Verifier); ok && v.verificationFailed() {
+			return nil, &DetectionError{Diagnostics: []string{cd.cloudDescription() + ": " + cd.cloudDiagnostic()}, ExitCode: unverifiedExitCode}
+		}
+	}
+
+	// The most confident match wins, and the first of them in the list
+	// when several are as confident.
+	if cd := mostConfident(cdList); cd != nil {
+		reportLayers(cd)
+		reportContainer(cd)
+		status.Cloud = cd.cloudDescription()
+		status.Attributes = cd.cloudAttributes()
+		return cd, nil
+	}
+
+	err := &DetectionError{ExitCode: detectionFailedExitCode(cdList)}
+	for _, cd := range cdList {
+		if cd.cloudDiagnostic() != "" {
+			err.Diagnostics = append(err.Diagnostics, cd.cloudDescription()+": "+cd.cloudDiagnostic())
+		}
+	}
+	return nil, err
+}
+
+// The first cloud in the list to match with high confidence, once every
+// cloud before it is done.  Those before it win ties, e.g. AWS ECS over
+// AWS, so it is not confirmed while they could still match.
+func confirmedCloud(cdList []CloudDetector, done []bool) CloudDetector {
+	for i, cd := range cdList {
+		if !done[i] {
+			return nil
+		}
+		if cd.isEffectiveCloud() && cd.cloudConfidence() >= provider.ConfidenceHigh {
+			return cd
+		}
+	}
+	return nil
+}
+
+// Clones and generic detectors match alongside the cloud they imitate,
+// e.g. EC2-compatible on OpenStack, so the most specific match wins.  The
+// order of the list breaks ties.
+func mostConfident(cdList []CloudDetector) CloudDetector {
+	var best CloudDetector
+	for _, cd := range cdList {
+		if !cd.isEffectiveCloud() {
+			continue
+		}
+		logOutput("%s matched with confidence %d\n", cd.cloudDescription(), cd.cloudConfidence())
+		if best == nil || cd.cloudConfidence() > best.cloudConfidence() {
+			best = cd
+		}
+	}
+	return best
+}
+
+// The -key flag may be given several times, each time with one key or a
+// comma separated list of them.
+type keyList []string
+
+func (l *keyList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *keyList) Set(value string) error {
+	for _, key := range strings.Split(value, ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			*l = append(*l, key)
+		}
+	}
+	return nil
+}
+
+// A key that is missing, rather than one the metadata service could not be
+// asked for, has the -default value when there is one.  Values that were
+// fetched are decoded with -decode, defaults are not.
+func fetchValue(ctx context.Context, cd CloudDetector, key string) (*string, error) {
+	val, err := fetchKey(ctx, cd, key)
+	if err == nil && len(globalOpts.decode) > 0 {
+		var decoded string
+		if decoded, err = decodeValue(*val, globalOpts.decode); err != nil {
+			return nil, &DecodeError{key, err}
+		}
+		return &decoded, nil
+	}
+	if err != nil && globalOpts.defaultValue != nil && keyErrorExitCode(err) == keyMissingExitCode {
+		logOutput("Using the default for the key %s: %s\n", key, err)
+		return globalOpts.defaultValue, nil
+	}
+	return val, err
+}
+
+// Fetch the keys concurrently, keeping them in the order they were given.
+func fetchKeys(ctx context.Context, cd CloudDetector, keys []string) []KeyValue {
+	values := make([]KeyValue, len(keys))
+	wg := sync.WaitGroup{}
+	wg.Add(len(keys))
+	for i, key := range keys {
+		values[i].Key = key
+		go func(kv *KeyValue) {
+			defer wg.Done()
+			val, err := fetchValue(ctx, cd, kv.Key)
+			if err != nil {
+				kv.Error = err.Error()
+				kv.code = keyErrorExitCode(err)
+				return
+			}
+			kv.Value = val
+		}(&values[i])
+	}
+	wg.Wait()
+	return values
+}
+
+func run(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	if globalOpts.all {
+		return runAll(ctx, cdList, status)
+	}
+	start := time.Now()
+	result := Result{Cloud: "UNKNOWN"}
+	cd := detect(ctx, cdList, status)
+	rc := detectionFailedExitCode(cdList)
+	if cd != nil {
+		rc = foundExitCode
+		if _, ok := cd.(*BareMetalDetector); ok {
+			rc = bareMetalExitCode
+		}
+		result.Cloud = cd.cloudDescription()
+		result.Id = cd.cloudFingerprint().ID
+		result.Detected = true
+		result.Attributes = cd.cloudAttributes()
+		// The keys have exit codes of their own, which say more than the
+		// one of the cloud when a key failed and nothing when none did.
+		keyRc := foundExitCode
+		if globalOpts.manifest != nil {
+			keyRc = applyManifest(ctx, cd, globalOpts.manifest)
+		} else if len(globalOpts.keys) > 1 {
+			status.Key = strings.Join(globalOpts.keys, ",")
+			result.Keys = fetchKeys(ctx, cd, globalOpts.keys)
+			for _, kv := range result.Keys {
+				if kv.Error != "" {
+					logOutput("Failed to get the key %s.  Error: %s\n", kv.Key, kv.Error)
+					status.Error = kv.Error
+					if kv.code > keyRc {
+						keyRc = kv.code
+					}
+				}
+			}
+		} else if globalOpts.key != "" {
+			status.Key = globalOpts.key
+			result.Key = globalOpts.key
+			val, err := fetchValue(ctx, cd, globalOpts.key)
+			if err != nil {
+				logOutput("Failed to get the key %s.  Error: %s\n", globalOpts.key, err)
+				status.Error = err.Error()
+				result.Error = err.Error()
+				keyRc = keyErrorExitCode(err)
+			} else {
+				result.Value = val
+			}
+		}
+		if keyRc != foundExitCode {
+			rc = keyRc
+		}
+	}
+	result.DurationMs = time.Since(start).Nanoseconds() / int64(time.Millisecond)
+	var err error
+	if globalOpts.template != nil {
+		err = writeTemplate(ctx, globalOpts.template, cd, result)
+	} else {
+		err = writeResult(result)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the result: %s\n", err)
+		return errorExitCode
+	}
+	return rc
+}
+
+type Command struct {
+	run         func(context.Context, []CloudDetector, *RunStatus) int
+	description string
+}
+
+var commands = map[string]Command{
+	"summary":          {runSummary, "Print the most commonly needed metadata of the cloud as JSON"},
+	"creds":            {runCreds, "Print the AWS role's temporary credentials as credential_process JSON, or a GCE or Azure access token"},
+	"dump":             {runDump, "Print the whole metadata of the cloud as one JSON document, without credentials"},
+	"identity":         {runIdentity, "Print the identity document the cloud signs for the instance, with its signature or token, as JSON"},
+	"info":             {runInfo, "Print the instance id, type, region, zone, IPs and hostname under the same names on every cloud as JSON"},
+	"keys":             {runKeys, "List commonly useful keys of the cloud, or of the one given with -cloud"},
+	"ssh-keys":         {runSSHKeys, "Print the SSH public keys provisioned for the instance in authorized_keys format"},
+	"tags":             {runTags, "Print the tags or labels of the instance as key=value lines, or with -format json or env"},
+	"user-data":        {runUserData, "Print the user data (or -vendor-data), with base64, gzip and the -part of a MIME archive taken care of"},
+	"watch":            {runWatch, "Print a key whenever it changes and run the -exec hook (AWS: the auto scaling lifecycle state)"},
+	"network":          {runNetwork, "Print the network interfaces of the instance, their MACs, IPs and subnets, as JSON"},
+	"secret":           {runSecret, "Print the value of the secret -name, fetched with the instance's own credentials (AWS: SSM or -secrets-manager, GCE: Secret Manager, Azure: Key Vault -vault)"},
+	"vault-login":      {runVaultLogin, "Log in to HashiCorp Vault as -vault-role with the cloud's identity (AWS IAM or EC2, GCE JWT, Azure managed identity) and print the token"},
+	"service-accounts": {runServiceAccounts, "List the service accounts of a GCE instance and their scopes as JSON"},
+}
+
+// Run the command line args, without the program name, and return the
+// exit code.
+func Main(args []string) int {
+	cdList := setupClouds()
+	ctx := context.Background()
+	command := Command{run: run}
+	name := ""
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		var ok bool
+		name = args[0]
+		command, ok = commands[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown command %s\n", args[0])
+			return usageExitCode
+		}
+		args = args[1:]
+	}
+	if rc, ok := setupOptions(cdList, args); !ok {
+		return rc
+	}
+	ctx = withSettings(ctx, &settings{
+		timeout:      globalOpts.timeout,
+		probeTimeout: globalOpts.probeTimeout,
+		retry: &retryPolicy{
+			count:    globalOpts.retries,
+			backoff:  globalOpts.retryBackoff,
+			jitter:   globalOpts.retryJitter,
+			timeouts: true},
+		walk: &walkPolicy{
+			rate:        globalOpts.walkRate,
+			concurrency: globalOpts.walkConcurrency,
+			maxKeys:     globalOpts.walkMaxKeys,
+			resume:      globalOpts.resume},
+		iface:           globalOpts.iface,
+		sourceAddr:      globalOpts.sourceAddr,
+		caBundle:        globalOpts.caBundle,
+		probeAllIfaces:  globalOpts.probeAllIfaces,
+		verify:          globalOpts.verify,
+		everyProbe:      globalOpts.all,
+		azureApiVersion: globalOpts.azureApiVersion})
+	var output *OutputFile
+	if globalOpts.output != "" {
+		// A watch never finishes, so its output would never be moved into
+		// place.
+		if name == "watch" {
+			fmt.Fprintf(os.Stderr, "-output cannot be used with the watch command\n")
+			return usageExitCode
+		}
+		var err error
+		output, err = openOutput(globalOpts.output, globalOpts.outputMode, globalOpts.outputOwner)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open the output file %s: %s\n", globalOpts.output, err)
+			return usageExitCode
+		}
+	}
+	status := newRunStatus()
+	rc := command.run(ctx, cdList, status)
+	if output != nil {
+		if err := output.close(rc == foundExitCode || rc == bareMetalExitCode); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the output file %s: %s\n", globalOpts.output, err)
+			rc = errorExitCode
+		}
+	}
+	if globalOpts.statusFile != "" {
+		status.finish(rc)
+		if err := status.write(globalOpts.statusFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write the status file %s: %s\n", globalOpts.statusFile, err)
+		}
+	}
+	return rc
+}
@@ -0,0 +1,251 @@
+package mycloud
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func onHypervisor(t *testing.T, signature string) {
+	saved := readCPUIDSignature
+	readCPUIDSignature = func() string { return signature }
+	t.Cleanup(func() { readCPUIDSignature = saved })
+}
+
+// ECS tasks on EC2 that can reach IMDS match both, and the layer on top
+// must win whatever the CPUID signature says.
+func TestECSWinsOverAWS(t *testing.T) {
+	for _, signature := range []string{"", "KVMKVMKVM\x00\x00\x00", "XenVMMXenVMM", "Microsoft Hv"} {
+		onHypervisor(t, signature)
+		ecs := NewECSCloud()
+		ecs.isMyCloud = true
+		aws := NewAWSCloud()
+		aws.isMyCloud = true
+		cdList := []CloudDetector{&ecs, &aws}
+
+		if best := mostConfident(cdList); best != &ecs {
+			t.Errorf("signature %q: mostConfident chose %s", signature, best.cloudDescription())
+		}
+		if confirmed := confirmedCloud(cdList, []bool{true, true}); confirmed != &ecs {
+			t.Errorf("signature %q: confirmedCloud chose %v", signature, confirmed)
+		}
+		if confirmed := confirmedCloud(cdList, []bool{false, true}); confirmed != nil {
+			t.Errorf("signature %q: AWS confirmed before ECS was done", signature)
+		}
+	}
+}
+
+func TestCPUIDConfidence(t *testing.T) {
+	aws := fingerprintFor("aws")
+	tests := []struct {
+		signature string
+		fp        Fingerprint
+		want      int
+	}{
+		{"KVMKVMKVM\x00\x00\x00", aws, 0},
+		{"Microsoft Hv", aws, cpuidDisagreement},
+		{"", aws, 0},
+		{"Microsoft Hv", fingerprintFor("ecs"), 0},
+	}
+	for _, test := range tests {
+		onHypervisor(t, test.signature)
+		if got := cpuidConfidence(test.fp); got != test.want {
+			t.Errorf("%s on %q: got %d, want %d", test.fp.Name, test.signature, got, test.want)
+		}
+	}
+}
+
+// Main is callable more than once, e.g. by a program that embeds the
+// command.
+func TestMainTwice(t *testing.T) {
+	for i := 0; i < 2; i++ {
+		if rc := Main([]string{"-retries", "-1"}); rc != usageExitCode {
+			t.Errorf("run %d exited with %d", i, rc)
+		}
+	}
+}
+
+// -redact only holds for the run it was given to.
+func TestRedactPerRun(t *testing.T) {
+	cdList := setupClouds()
+	if _, ok := setupOptions(cdList, []string{"-redact", "colou?r"}); !ok {
+		t.Fatal("-redact was not accepted")
+	}
+	if got := redact("colour=blue"); got != "colour=<redacted>" {
+		t.Errorf("with -redact: %s", got)
+	}
+	if _, ok := setupOptions(cdList, nil); !ok {
+		t.Fatal("no options were not accepted")
+	}
+	if got := redact("colour=blue"); got != "colour=blue" {
+		t.Errorf("after a run with -redact: %s", got)
+	}
+}
+
+func TestSignalWeights(t *testing.T) {
+	onHypervisor(t, "")
+	db := loadedFingerprints()
+	saved := db.Weights
+	db.Weights = map[string]int{signalHTTP: -60, signalDMI: 30}
+	t.Cleanup(func() { db.Weights = saved })
+
+	aws := NewAWSCloud()
+	aws.isMyCloud = true
+	vbox := NewVirtualBoxDetector()
+	vbox.isMyCloud = true
+	vbox.signal = signalDMI
+	nocloud := NewNoCloudDetector()
+	nocloud.isMyCloud = true
+	nocloud.signal = signalFiles
+
+	tests := []struct {
+		cd   CloudDetector
+		want int
+	}{
+		{&aws, 30},
+		{&vbox, 40},
+		{&nocloud, 50},
+	}
+	for _, test := range tests {
+		if got := test.cd.cloudConfidence(); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.cd.cloudDescription(), got, test.want)
+		}
+	}
+	if best := mostConfident([]CloudDetector{&aws, &vbox, &nocloud}); best != &nocloud {
+		t.Errorf("mostConfident chose %s", best.cloudDescription())
+	}
+}
+
+func TestGCEKeyPath(t *testing.T) {
+	tests := map[string]string{
+		"zone":                        "instance/zone",
+		"/zone":                       "instance/zone",
+		"instance/zone":               "instance/zone",
+		"project/attributes/ssh-keys": "project/attributes/ssh-keys",
+		"oslogin/users":               "oslogin/users",
+		"universe/universe_domain":    "universe/universe_domain",
+		"attributes/":                 "instance/attributes/",
+		"projects":                    "instance/projects",
+		"":                            "",
+		"/":                           "",
+	}
+	for key, want := range tests {
+		if got := gceKeyPath(key); got != want {
+			t.Errorf("%q: got %q, want %q", key, got, want)
+		}
+	}
+}
+
+// Anything at 169.254.169.254, a throttling proxy too, may answer 429, so
+// a throttled match must not beat one whose answer was checked.
+func TestThrottledMatchIsLow(t *testing.T) {
+	onHypervisor(t, "")
+	throttled := &ThrottledError{URL: "http://169.254.169.254/latest/meta-data/", Status: "429 Too Many Requests"}
+	aws := NewAWSCloud()
+	aws.checkResponse(nil, throttled)
+	clone := NewEC2CloneCloud("outscale")
+	clone.checkResponse(nil, throttled)
+	openStack := NewOpenStackCloud()
+	doc := `{"uuid": "83679162-1378-4288-a2d4-70e13ec132aa"}`
+	openStack.checkResponse(&doc, nil)
+
+	if !aws.isEffectiveCloud() || aws.cloudConfidence() != 10 {
+		t.Errorf("throttled AWS: matched %v with confidence %d", aws.isEffectiveCloud(), aws.cloudConfidence())
+	}
+	if best := mostConfident([]CloudDetector{&aws, &clone, &openStack}); best != &openStack {
+		t.Errorf("mostConfident chose %s", best.cloudDescription())
+	}
+	if confirmed := confirmedCloud([]CloudDetector{&aws, &clone}, []bool{true, true}); confirmed != nil {
+		t.Errorf("%s was confirmed on a throttled answer", confirmed.cloudDescription())
+	}
+}
+
+func TestAWSZoneType(t *testing.T) {
+	tests := []struct {
+		region, zone, want string
+	}{
+		{"us-east-1", "us-east-1a", "availability-zone"},
+		{"us-west-2", "us-west-2-lax-1a", "local-zone"},
+		{"us-east-1", "us-east-1-wl1-bos-wlz-1", "wavelength-zone"},
+		{"us-east-1", "us-east-1-new-kind", "unknown"},
+		{"us-east-1", "eu-west-1a", "unknown"},
+		{"us-east-1", "us-east-1", "unknown"},
+	}
+	for _, test := range tests {
+		if got := awsZoneType(test.region, test.zone); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.zone, got, test.want)
+		}
+	}
+}
+
+// Errors that are wrapped on the way up keep their exit codes.
+func TestKeyErrorExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{&DecodeError{"user-data", errors.New("not base64")}, errorExitCode},
+		{fmt.Errorf("dump: %w", &WalkLimitError{10}), errorExitCode},
+		{fmt.Errorf("identity: %w", &VerificationError{"forged"}), unverifiedExitCode},
+		{fmt.Errorf("key: %w", &HTTPStatusError{StatusCode: 404}), keyMissingExitCode},
+		{fmt.Errorf("key: %w", &ThrottledError{}), unreachableExitCode},
+	}
+	for _, test := range tests {
+		if got := keyErrorExitCode(test.err); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.err, got, test.want)
+		}
+	}
+}
+
+type keysOnlyDetector struct{}
+
+func (keysOnlyDetector) Name() string                                        { return "Keys only" }
+func (keysOnlyDetector) Detect(ctx context.Context) (bool, error)            { return true, nil }
+func (keysOnlyDetector) Get(ctx context.Context, key string) (string, error) { return "", nil }
+func (keysOnlyDetector) SSHKeys(ctx context.Context) ([]string, error) {
+	return []string{"ssh-ed25519 AAAA"}, nil
+}
+
+// A registered detector offers what it implements, and only that, though
+// a registeredCloud has every method.
+func TestRegisteredCapabilities(t *testing.T) {
+	cd := &registeredCloud{detector: keysOnlyDetector{}}
+	source, ok := sshKeySourceOf(cd)
+	if !ok {
+		t.Fatal("the SSH keys are not offered")
+	}
+	if keys, err := source.sshKeys(context.Background()); err != nil || len(keys) != 1 {
+		t.Errorf("sshKeys returned %v, %v", keys, err)
+	}
+	if _, ok := vendorDataSourceOf(cd); ok {
+		t.Error("vendor data is offered")
+	}
+	if _, ok := accountIdentifierOf(cd); ok {
+		t.Error("the account is offered")
+	}
+	aws := NewAWSCloud()
+	if _, ok := sshKeySourceOf(&aws); !ok {
+		t.Error("AWS does not offer its SSH keys")
+	}
+}
+
+// A certificate the client does not trust is a TLS failure, not a failed
+// connection.
+func TestClassifyTLSError(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0)
+	server.StartTLS()
+	defer server.Close()
+	_, err := http.Get(server.URL)
+	if err == nil {
+		t.Fatal("the server's certificate was trusted")
+	}
+	if category := classifyError(err); category != ErrorCategoryTLS {
+		t.Errorf("%s is classified as %s", err, category)
+	}
+}
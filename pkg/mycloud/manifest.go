@@ -0,0 +1,136 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// A key and the file its value is written to.  The mode is octal and
+// defaults to 0600 since user data and the like often hold secrets.
+type ManifestEntry struct {
+	Key  string `json:"key"`
+	Path string `json:"path"`
+	Mode string `json:"mode"`
+}
+
+const manifestDefaultMode = 0600
+
+// Manifests are JSON, or the YAML that says the same:
+//
+//	files:
+//	  - key: user-data
+//	    path: /etc/app/user-data
+//	    mode: "0600"
+func readManifest(path string) ([]ManifestEntry, error) {
+	doc, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var manifest struct {
+		Files []ManifestEntry `json:"files"`
+	}
+	if trimmed := strings.TrimSpace(string(doc)); strings.HasPrefix(trimmed, "{") {
+		err = json.Unmarshal(doc, &manifest)
+	} else {
+		manifest.Files, err = parseManifestYAML(string(doc))
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range manifest.Files {
+		if entry.Key == "" || entry.Path == "" {
+			return nil, errors.New("Every file in the manifest needs a key and a path")
+		}
+		if _, err := entry.fileMode(); err != nil {
+			return nil, errors.New("Invalid mode " + entry.Mode + " for " + entry.Path)
+		}
+	}
+	return manifest.Files, nil
+}
+
+// Only the list of flat entries above is understood, which is all a
+// manifest needs and keeps this free of a YAML dependency.
+func parseManifestYAML(doc string) ([]ManifestEntry, error) {
+	var entries []ManifestEntry
+	for n, line := range strings.Split(doc, "\n") {
+		if i := strings.Index(line, " #"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "files:" {
+			continue
+		}
+		if strings.HasPrefix(line, "- ") || line == "-" {
+			entries = append(entries, ManifestEntry{})
+			line = strings.TrimSpace(strings.TrimPrefix(line, "-"))
+			if line == "" {
+				continue
+			}
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(entries) == 0 || len(parts) != 2 {
+			return nil, errors.New("Cannot parse line " + strconv.Itoa(n+1) + " of the manifest")
+		}
+		value := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		entry := &entries[len(entries)-1]
+		switch strings.TrimSpace(parts[0]) {
+		case "key":
+			entry.Key = value
+		case "path":
+			entry.Path = value
+		case "mode":
+			entry.Mode = value
+		default:
+			return nil, errors.New("Unknown field " + parts[0] + " on line " + strconv.Itoa(n+1) + " of the manifest")
+		}
+	}
+	return entries, nil
+}
+
+func (e ManifestEntry) fileMode() (os.FileMode, error) {
+	if e.Mode == "" {
+		return manifestDefaultMode, nil
+	}
+	mode, err := strconv.ParseUint(e.Mode, 8, 32)
+	if err != nil || mode > 0777 {
+		return 0, errors.New("Invalid mode " + e.Mode)
+	}
+	return os.FileMode(mode), nil
+}
+
+// Fetch every key concurrently and write each value to its file as it is,
+// without the newline the text output adds.  Keys that cannot be had leave
+// their files alone.
+func applyManifest(ctx context.Context, cd CloudDetector, entries []ManifestEntry) int {
+	keys := make([]string, len(entries))
+	for i, entry := range entries {
+		keys[i] = entry.Key
+	}
+	rc := foundExitCode
+	for i, kv := range fetchKeys(ctx, cd, keys) {
+		entry := entries[i]
+		if kv.Error != "" {
+			fmt.Fprintf(os.Stderr, "Failed to get the key %s for %s: %s\n", kv.Key, entry.Path, kv.Error)
+			if kv.code > rc {
+				rc = kv.code
+			}
+			continue
+		}
+		mode, _ := entry.fileMode()
+		if err := writeFileAtomic(entry.Path, []byte(*kv.Value), mode); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to write %s: %s\n", entry.Path, err)
+			if rc == foundExitCode {
+				rc = errorExitCode
+			}
+			continue
+		}
+		logOutput("Wrote the key %s to %s\n", kv.Key, entry.Path)
+	}
+	return rc
+}
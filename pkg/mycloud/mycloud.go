@@ -0,0 +1,116 @@
+// Package mycloud detects the cloud a program is running in and reads the
+// metadata the cloud provides, as the mycloud command does.
+//
+//	cloud, err := mycloud.Detect(ctx)
+//	if err != nil {
+//		return err
+//	}
+//	info, err := cloud.Info(ctx)
+package mycloud
+
+import (
+	"context"
+	"strings"
+)
+
+// A detected cloud.
+type Cloud interface {
+	// The name mycloud prints, e.g. AWS.
+	Name() string
+	// The id of the cloud in the fingerprints, e.g. aws.
+	ID() string
+	Attributes() map[string]string
+	SupportsKeys() bool
+	// How sure detection is, one of the provider.Confidence levels.
+	Confidence() int
+	// The value of a metadata key, in the cloud's own key names.
+	Get(ctx context.Context, key string) (string, error)
+	// The most commonly needed metadata under the cloud's own names.
+	Summary(ctx context.Context) (*Summary, error)
+	// The same fields under the same names on every cloud.
+	Info(ctx context.Context) (*Info, error)
+	// What each detector found, skipped or stopped, in the order they are
+	// tried.
+	Probes() []ProbeStatus
+}
+
+// Returned by Detect when no cloud was found.  Diagnostics explains the
+// likely clouds that could not be confirmed, and ExitCode is what the
+// mycloud command would exit with.
+type DetectionError struct {
+	Diagnostics []string
+	ExitCode    int
+	Probes      []ProbeStatus
+}
+
+func (e *DetectionError) Error() string {
+	if len(e.Diagnostics) == 0 {
+		return "No cloud was detected"
+	}
+	return "No cloud was detected: " + strings.Join(e.Diagnostics, "; ")
+}
+
+type detectedCloud struct {
+	cd       CloudDetector
+	settings *settings
+	probes   []ProbeStatus
+}
+
+func (c *detectedCloud) Name() string {
+	return c.cd.cloudDescription()
+}
+
+func (c *detectedCloud) ID() string {
+	return c.cd.cloudFingerprint().ID
+}
+
+func (c *detectedCloud) Attributes() map[string]string {
+	return c.cd.cloudAttributes()
+}
+
+func (c *detectedCloud) SupportsKeys() bool {
+	return c.cd.supportsKeys()
+}
+
+func (c *detectedCloud) Confidence() int {
+	return c.cd.cloudConfidence()
+}
+
+func (c *detectedCloud) Probes() []ProbeStatus {
+	return c.probes
+}
+
+func (c *detectedCloud) Get(ctx context.Context, key string) (string, error) {
+	val, err := fetchKey(withSettings(ctx, c.settings), c.cd, key)
+	if err != nil {
+		return "", err
+	}
+	return *val, nil
+}
+
+func (c *detectedCloud) Summary(ctx context.Context) (*Summary, error) {
+	s := summarize(withSettings(ctx, c.settings), c.cd)
+	return &s, nil
+}
+
+func (c *detectedCloud) Info(ctx context.Context) (*Info, error) {
+	info := normalize(withSettings(ctx, c.settings), c.cd)
+	return &info, nil
+}
+
+// Probe the clouds the way the mycloud command does and return the one
+// the program is running in, bare metal included.  The options apply to
+// the returned Cloud's requests too.
+func Detect(ctx context.Context, opts ...Option) (Cloud, error) {
+	s := &settings{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	status := newRunStatus()
+	cd, err := detectCloud(withSettings(ctx, s), setupClouds(), status)
+	if err != nil {
+		err.Probes = status.Probes
+		return nil, err
+	}
+	return &detectedCloud{cd, s, status.Probes}, nil
+}
@@ -0,0 +1,312 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An interface of the instance as the cloud sees it, named the same on
+// every cloud.  The network is the VPC or virtual network the subnet is in.
+type NetworkInterface struct {
+	Mac        string   `json:"mac"`
+	PrivateIps []string `json:"private_ips"`
+	PublicIps  []string `json:"public_ips,omitempty"`
+	Ipv6s      []string `json:"ipv6s,omitempty"`
+	SubnetId   string   `json:"subnet_id,omitempty"`
+	SubnetCidr string   `json:"subnet_cidr,omitempty"`
+	NetworkId  string   `json:"network_id,omitempty"`
+}
+
+type NetworkReport struct {
+	Provider   string             `json:"provider"`
+	Interfaces []NetworkInterface `json:"interfaces"`
+}
+
+// Clouds that describe the network interfaces of the instance.
+type NetworkReporter interface {
+	networkInterfaces(context.Context) ([]NetworkInterface, error)
+}
+
+// MACs are printed lower case with colons, which Azure leaves out.
+func normalizeMac(mac string) string {
+	mac = strings.ToLower(strings.TrimSpace(mac))
+	if len(mac) != 12 || strings.ContainsAny(mac, ":-") {
+		return strings.Replace(mac, "-", ":", -1)
+	}
+	var parts []string
+	for i := 0; i < 12; i += 2 {
+		parts = append(parts, mac[i:i+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+func runNetwork(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	reporter, ok := cd.(NetworkReporter)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Network interfaces are not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	interfaces, err := reporter.networkInterfaces(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the network interfaces: %s\n", err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	for i := range interfaces {
+		interfaces[i].Mac = normalizeMac(interfaces[i].Mac)
+		if interfaces[i].PrivateIps == nil {
+			interfaces[i].PrivateIps = []string{}
+		}
+	}
+	out, err := json.MarshalIndent(NetworkReport{cd.cloudDescription(), interfaces}, "", "  ")
+	if err != nil {
+		status.Error = err.Error()
+		return errorExitCode
+	}
+	fmt.Printf("%s\n", out)
+	return foundExitCode
+}
+
+func splitLines(value string) []string {
+	var out []string
+	for _, line := range strings.Split(value, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			out = append(out, line)
+		}
+	}
+	return out
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+// Every interface has a directory under network/interfaces/macs/, ordered
+// here by device number so the primary interface comes first.
+func (c *AWSCloud) networkInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	listing, err := c.getKey(ctx, "network/interfaces/macs/")
+	if err != nil {
+		return nil, err
+	}
+	var interfaces []NetworkInterface
+	devices := map[string]int{}
+	for _, mac := range splitLines(*listing) {
+		mac = strings.TrimSuffix(mac, "/")
+		dir := "network/interfaces/macs/" + mac + "/"
+		values := fetchFields(ctx, c, []summaryField{
+			{"device-number", dir + "device-number", nil},
+			{"local-ipv4s", dir + "local-ipv4s", nil},
+			{"public-ipv4s", dir + "public-ipv4s", nil},
+			{"ipv6s", dir + "ipv6s", nil},
+			{"subnet-id", dir + "subnet-id", nil},
+			{"subnet-ipv4-cidr-block", dir + "subnet-ipv4-cidr-block", nil},
+			{"vpc-id", dir + "vpc-id", nil},
+		})
+		devices[mac], _ = strconv.Atoi(values["device-number"])
+		interfaces = append(interfaces, NetworkInterface{
+			Mac:        mac,
+			PrivateIps: splitLines(values["local-ipv4s"]),
+			PublicIps:  splitLines(values["public-ipv4s"]),
+			Ipv6s:      splitLines(values["ipv6s"]),
+			SubnetId:   values["subnet-id"],
+			SubnetCidr: values["subnet-ipv4-cidr-block"],
+			NetworkId:  values["vpc-id"],
+		})
+	}
+	sort.SliceStable(interfaces, func(i, j int) bool {
+		return devices[interfaces[i].Mac] < devices[interfaces[j].Mac]
+	})
+	return interfaces, nil
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// GCE gives the network of an interface but not its subnetwork.
+func (c *GCECloud) networkInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	doc, err := c.getKey(ctx, "instance/network-interfaces/?recursive=true&alt=json")
+	if err != nil {
+		return nil, err
+	}
+	var nics []struct {
+		Mac           string   `json:"mac"`
+		Ip            string   `json:"ip"`
+		Ipv6s         []string `json:"ipv6s"`
+		Network       string   `json:"network"`
+		AccessConfigs []struct {
+			ExternalIp string `json:"externalIp"`
+		} `json:"accessConfigs"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &nics); err != nil {
+		return nil, err
+	}
+	var interfaces []NetworkInterface
+	for _, nic := range nics {
+		n := NetworkInterface{Mac: nic.Mac, PrivateIps: []string{nic.Ip}, Ipv6s: nic.Ipv6s, NetworkId: nic.Network}
+		for _, config := range nic.AccessConfigs {
+			if config.ExternalIp != "" {
+				n.PublicIps = append(n.PublicIps, config.ExternalIp)
+			}
+		}
+		interfaces = append(interfaces, n)
+	}
+	return interfaces, nil
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+// IMDS has the subnet's prefix but neither its id nor the virtual network.
+func (c *AzureCloud) networkInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	doc, err := c.getKey(ctx, "network")
+	if err != nil {
+		return nil, err
+	}
+	type azureIps struct {
+		IpAddress []struct {
+			PrivateIpAddress string `json:"privateIpAddress"`
+			PublicIpAddress  string `json:"publicIpAddress"`
+		} `json:"ipAddress"`
+		Subnet []struct {
+			Address string `json:"address"`
+			Prefix  string `json:"prefix"`
+		} `json:"subnet"`
+	}
+	var network struct {
+		Interface []struct {
+			MacAddress string   `json:"macAddress"`
+			Ipv4       azureIps `json:"ipv4"`
+			Ipv6       azureIps `json:"ipv6"`
+		} `json:"interface"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &network); err != nil {
+		return nil, err
+	}
+	var interfaces []NetworkInterface
+	for _, nic := range network.Interface {
+		n := NetworkInterface{Mac: nic.MacAddress}
+		for _, ip := range nic.Ipv4.IpAddress {
+			n.PrivateIps = append(n.PrivateIps, ip.PrivateIpAddress)
+			if ip.PublicIpAddress != "" {
+				n.PublicIps = append(n.PublicIps, ip.PublicIpAddress)
+			}
+		}
+		for _, ip := range nic.Ipv6.IpAddress {
+			n.Ipv6s = append(n.Ipv6s, ip.PrivateIpAddress)
+		}
+		if len(nic.Ipv4.Subnet) > 0 {
+			n.SubnetCidr = nic.Ipv4.Subnet[0].Address + "/" + nic.Ipv4.Subnet[0].Prefix
+		}
+		interfaces = append(interfaces, n)
+	}
+	return interfaces, nil
+}
+
+/////////////////////////////////////////////////////////
+// OpenStack
+/////////////////////////////////////////////////////////
+// network_data.json lists the links, which carry the MACs, and the networks
+// on each link.  Floating IPs are NAT and appear in neither.
+func (c *OpenStackCloud) networkInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	var doc *string
+	if c.attributes["openstack.metadata-source"] == "config-drive" {
+		b, err := readConfigDrive(c.fingerprint.Files, "openstack/latest/network_data.json")
+		if err != nil {
+			return nil, err
+		}
+		s := string(b)
+		doc = &s
+	} else {
+		var err error
+		if doc, _, err = getUrl(ctx, c.baseUrl+c.version+"/network_data.json", c.headers); err != nil {
+			return nil, err
+		}
+	}
+	var data struct {
+		Links []struct {
+			Id  string `json:"id"`
+			Mac string `json:"ethernet_mac_address"`
+		} `json:"links"`
+		Networks []struct {
+			Link      string `json:"link"`
+			Type      string `json:"type"`
+			IpAddress string `json:"ip_address"`
+			NetworkId string `json:"network_id"`
+		} `json:"networks"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &data); err != nil {
+		return nil, err
+	}
+	var interfaces []NetworkInterface
+	for _, link := range data.Links {
+		n := NetworkInterface{Mac: link.Mac}
+		for _, network := range data.Networks {
+			if network.Link != link.Id {
+				continue
+			}
+			if n.NetworkId == "" {
+				n.NetworkId = network.NetworkId
+			}
+			switch {
+			case network.IpAddress == "":
+			case strings.HasSuffix(network.Type, "6"):
+				n.Ipv6s = append(n.Ipv6s, network.IpAddress)
+			default:
+				n.PrivateIps = append(n.PrivateIps, network.IpAddress)
+			}
+		}
+		interfaces = append(interfaces, n)
+	}
+	return interfaces, nil
+}
+
+/////////////////////////////////////////////////////////
+// Digital Ocean
+/////////////////////////////////////////////////////////
+// Droplets have a public interface and, in a VPC, a private one.  Their
+// addresses are public and private respectively.
+func (c *DigitalOceanCloud) networkInterfaces(ctx context.Context) ([]NetworkInterface, error) {
+	doc, _, err := getUrl(ctx, strings.TrimSuffix(c.baseUrl, "/")+".json", c.headers)
+	if err != nil {
+		return nil, err
+	}
+	type doInterface struct {
+		Mac  string `json:"mac"`
+		Ipv4 struct {
+			IpAddress string `json:"ip_address"`
+		} `json:"ipv4"`
+		Ipv6 struct {
+			IpAddress string `json:"ip_address"`
+		} `json:"ipv6"`
+	}
+	var droplet struct {
+		Interfaces struct {
+			Public  []doInterface `json:"public"`
+			Private []doInterface `json:"private"`
+		} `json:"interfaces"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &droplet); err != nil {
+		return nil, err
+	}
+	var interfaces []NetworkInterface
+	for _, nic := range droplet.Interfaces.Public {
+		n := NetworkInterface{Mac: nic.Mac, PublicIps: []string{nic.Ipv4.IpAddress}}
+		if nic.Ipv6.IpAddress != "" {
+			n.Ipv6s = []string{nic.Ipv6.IpAddress}
+		}
+		interfaces = append(interfaces, n)
+	}
+	for _, nic := range droplet.Interfaces.Private {
+		interfaces = append(interfaces, NetworkInterface{Mac: nic.Mac, PrivateIps: []string{nic.Ipv4.IpAddress}})
+	}
+	return interfaces, nil
+}
@@ -0,0 +1,55 @@
+package mycloud
+
+import (
+	"context"
+	"errors"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// cloud-init NoCloud seeds
+/////////////////////////////////////////////////////////
+type NoCloudDetector struct {
+	BaseCloud
+	seed *seedDrive
+}
+
+// Homelab and CI VMs under libvirt or plain QEMU have no metadata server,
+// they are handed a cidata volume or have a seed directory baked into the
+// image.  Proxmox seeds look the same, so NoCloud is only as confident as
+// a generic match and Proxmox wins when both match.
+func NewNoCloudDetector() NoCloudDetector {
+	c := NoCloudDetector{}
+	c.fingerprint = fingerprintFor("nocloud")
+	c.supportsKey = true
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceMedium
+	return c
+}
+
+func (c *NoCloudDetector) detectEffectiveCloud(ctx context.Context) {
+	c.seed = findSeedDrive(c.fingerprint.Files)
+	c.isMyCloud = c.seed != nil && !c.seed.configDrive
+	c.signal = signalFiles
+}
+
+// Keys are the top level names in the seed's meta-data, e.g.
+// local-hostname.
+func (c *NoCloudDetector) getKey(ctx context.Context, key string) (*string, error) {
+	if c.seed == nil {
+		return nil, errors.New("No NoCloud seed was found")
+	}
+	return c.seed.metadataKey(key)
+}
+
+func (c *NoCloudDetector) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "instance-id", nil},
+		{"hostname", "local-hostname", nil},
+	}
+}
+
+func (c *NoCloudDetector) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
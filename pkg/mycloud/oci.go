@@ -0,0 +1,33 @@
+package mycloud
+
+import "context"
+
+/////////////////////////////////////////////////////////
+// Oracle Cloud Infrastructure
+/////////////////////////////////////////////////////////
+type OCICloud struct {
+	SimpleUrlBasedCloud
+}
+
+// The v2 metadata endpoints refuse requests without the Bearer header,
+// which is set in the fingerprint.
+func NewOCICloud() OCICloud {
+	c := OCICloud{}
+	c.setFingerprint(fingerprintFor("oci"))
+	c.supportsKey = true
+	return c
+}
+
+func (c *OCICloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "id", nil},
+		{"instance_type", "shape", nil},
+		{"region", "canonicalRegionName", nil},
+		{"zone", "availabilityDomain", nil},
+		{"hostname", "hostname", nil},
+	}
+}
+
+func (c *OCICloud) tags(ctx context.Context) (map[string]string, error) {
+	return jsonTags(ctx, c, "freeformTags")
+}
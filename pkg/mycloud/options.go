@@ -0,0 +1,259 @@
+package mycloud
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// How Detect and the Cloud it returns make their requests.  The settings
+// travel with the context so that every request of a detection sees them.
+type settings struct {
+	client  *http.Client
+	timeout time.Duration
+	// The timeout of the requests detection makes, when it is not timeout.
+	probeTimeout time.Duration
+	// defaultRetryPolicy when nil.
+	retry *retryPolicy
+	// defaultWalkPolicy when nil.
+	walk *walkPolicy
+	// The metadata service addresses, as scheme://host, that requests
+	// are sent elsewhere from.
+	origins map[string]string
+	// How the requests get to the metadata service, see WithInterface.
+	iface          string
+	sourceAddr     net.IP
+	caBundle       string
+	probeAllIfaces bool
+	// Check the identity document of the cloud found, see WithVerify.
+	verify bool
+	// Probe every cloud to the end, see WithEveryProbe.
+	everyProbe bool
+	// Shared by the detections of a long-running program, see
+	// WithProbeBreaker.
+	breaker *ProbeBreaker
+	// The Azure IMDS api-version, negotiated when empty.
+	azureApiVersion string
+}
+
+// An option of Detect.
+type Option func(*settings)
+
+// Send the requests with client, e.g. one with a proxy or a transport of
+// its own.  Its Timeout is kept when it has one.
+func WithHTTPClient(client *http.Client) Option {
+	return func(s *settings) {
+		s.client = client
+	}
+}
+
+// How long a request to a metadata service may take, one second by
+// default.
+func WithTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		s.timeout = timeout
+	}
+}
+
+// How long a request to a metadata service may take while the clouds are
+// probed, WithTimeout's by default.  A short one keeps detection quick
+// where most clouds do not answer, a long one is for metadata services
+// that are slow right after boot.
+func WithProbeTimeout(timeout time.Duration) Option {
+	return func(s *settings) {
+		s.probeTimeout = timeout
+	}
+}
+
+// How often a request is retried when the metadata service is busy or
+// does not answer in time, 3 times by default.  The waits double from
+// backoff, 100ms by default, and jitter is the fraction of each that is
+// random.  A count of 0 turns retrying off.
+func WithRetries(count int, backoff time.Duration, jitter float64) Option {
+	return func(s *settings) {
+		s.retry = &retryPolicy{count: count, backoff: backoff, jitter: jitter, timeouts: true}
+	}
+}
+
+// How Get walks a directory, a key with a trailing /, on clouds that need
+// a request per path.  rate is the requests a second, 50 by default,
+// concurrency how many are sent at once, 4 by default, and maxKeys how
+// many a walk may make, 5000 by default.  A rate or maxKeys of 0 is no
+// limit.
+func WithTreeWalk(rate float64, concurrency int, maxKeys int) Option {
+	return func(s *settings) {
+		s.walk = &walkPolicy{rate: rate, concurrency: concurrency, maxKeys: maxKeys}
+	}
+}
+
+// Send the requests through the network interface iface, on hosts where
+// the default route does not reach the metadata service.
+func WithInterface(iface string) Option {
+	return func(s *settings) {
+		s.iface = iface
+	}
+}
+
+// Send the requests from the local address addr.
+func WithSourceAddress(addr net.IP) Option {
+	return func(s *settings) {
+		s.sourceAddr = addr
+	}
+}
+
+// Trust the CA certificates in the PEM file path too, for metadata
+// services on https with a private CA.
+func WithCABundle(path string) Option {
+	return func(s *settings) {
+		s.caBundle = path
+	}
+}
+
+// Retry the requests that cannot connect on every interface that is up,
+// and keep using the one that worked.
+func WithProbeAllInterfaces() Option {
+	return func(s *settings) {
+		s.probeAllIfaces = true
+	}
+}
+
+// Check the signature of the AWS identity document while detecting.  A
+// document that is not AWS's fails detection with ExitCode 7.
+func WithVerify() Option {
+	return func(s *settings) {
+		s.verify = true
+	}
+}
+
+// Probe every cloud to the end, whatever the DMI data says and after a
+// cloud is confirmed, as the -all flag does, so that the Probes have every
+// verdict.  Detection takes as long as its slowest probe.
+func WithEveryProbe() Option {
+	return func(s *settings) {
+		s.everyProbe = true
+	}
+}
+
+// Skip the clouds whose probes failed in the last detections that used
+// breaker, one from NewProbeBreaker, for programs that detect again and
+// again, such as every time they refresh.  WithEveryProbe ignores it.
+func WithProbeBreaker(breaker ProbeBreaker) Option {
+	return func(s *settings) {
+		s.breaker = &breaker
+	}
+}
+
+// Use version as the Azure IMDS api-version instead of negotiating one,
+// e.g. for an Azure Stack that lists versions it does not answer.
+func WithAzureAPIVersion(version string) Option {
+	return func(s *settings) {
+		s.azureApiVersion = version
+	}
+}
+
+// Send the requests for the metadata service of the cloud id, e.g. aws,
+// to baseUrl instead, such as a fake one in a test.  Clouds that share the
+// address, like the many at 169.254.169.254, are sent there too.
+func WithBaseURL(id string, baseUrl string) Option {
+	return func(s *settings) {
+		from := originOf(fingerprintFor(id).BaseUrl)
+		if from == "" {
+			from = originOf(fingerprintFor(id).TestUrl)
+		}
+		if from == "" {
+			return
+		}
+		if s.origins == nil {
+			s.origins = map[string]string{}
+		}
+		s.origins[from] = strings.TrimSuffix(baseUrl, "/")
+	}
+}
+
+func originOf(rawUrl string) string {
+	u, err := url.Parse(rawUrl)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+type settingsKey struct{}
+
+func withSettings(ctx context.Context, s *settings) context.Context {
+	return context.WithValue(ctx, settingsKey{}, s)
+}
+
+func settingsOf(ctx context.Context) *settings {
+	if s, ok := ctx.Value(settingsKey{}).(*settings); ok {
+		return s
+	}
+	return &settings{}
+}
+
+func (s *settings) retrying() retryPolicy {
+	if s.retry == nil {
+		return defaultRetryPolicy
+	}
+	return *s.retry
+}
+
+func (s *settings) walking() walkPolicy {
+	if s.walk == nil {
+		return defaultWalkPolicy
+	}
+	walk := *s.walk
+	if walk.concurrency < 1 {
+		walk.concurrency = 1
+	}
+	return walk
+}
+
+// The settings of the probes of detection.  Most of the clouds probed are
+// not there to answer, so their timeouts are not retried.
+func (s *settings) probing() *settings {
+	probing := *s
+	if s.probeTimeout != 0 {
+		probing.timeout = s.probeTimeout
+	}
+	retry := s.retrying()
+	retry.timeouts = false
+	probing.retry = &retry
+	return &probing
+}
+
+// The settings of the probe of the cloud the DMI data names, whose
+// metadata service is there even when it is slow to answer.
+func (s *settings) expected() *settings {
+	expected := *s
+	retry := s.retrying()
+	retry.timeouts = true
+	expected.retry = &retry
+	return &expected
+}
+
+// The client for a request that may take timeout, unless the caller gave
+// one of their own.
+func (s *settings) clientFor(timeout time.Duration, transport http.RoundTripper) *http.Client {
+	if s.client != nil {
+		if s.client.Timeout != 0 {
+			return s.client
+		}
+		client := *s.client
+		client.Timeout = timeout
+		return &client
+	}
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func (s *settings) rewrite(rawUrl string) string {
+	for from, to := range s.origins {
+		if rawUrl == from || strings.HasPrefix(rawUrl, from+"/") {
+			return to + rawUrl[len(from):]
+		}
+	}
+	return rawUrl
+}
@@ -0,0 +1,234 @@
+package mycloud
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// What a run found, for the output formats that want more than the bare
+// lines of the text format.
+type Result struct {
+	Cloud      string            `json:"cloud"`
+	Id         string            `json:"id,omitempty"`
+	Detected   bool              `json:"detected"`
+	Key        string            `json:"key,omitempty"`
+	Value      *string           `json:"value,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Keys       []KeyValue        `json:"keys,omitempty"`
+	DurationMs int64             `json:"duration_ms"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+// One of several keys asked for at once.
+type KeyValue struct {
+	Key   string  `json:"key"`
+	Value *string `json:"value,omitempty"`
+	Error string  `json:"error,omitempty"`
+	code  int
+}
+
+var resultWriters = map[string]func(Result) error{
+	"text": writeText,
+	"json": writeJSON,
+	"env":  writeEnv,
+	"raw":  writeRaw,
+}
+
+func writeResult(result Result) error {
+	return resultWriters[globalOpts.format](result)
+}
+
+// The cloud, then the key's value or UNKNOWN when it could not be had, then
+// the attributes with -details.
+func writeText(result Result) error {
+	fmt.Printf("%s\n", result.Cloud)
+	if !result.Detected {
+		return nil
+	}
+	if result.Key != "" {
+		if result.Value != nil {
+			fmt.Printf("%s\n", *result.Value)
+		} else {
+			fmt.Printf("UNKNOWN\n")
+		}
+	}
+	for _, kv := range result.Keys {
+		if kv.Value != nil {
+			fmt.Printf("%s=%s\n", kv.Key, *kv.Value)
+		} else {
+			fmt.Printf("%s=UNKNOWN\n", kv.Key)
+		}
+	}
+	if globalOpts.details {
+		printAttributes(result.Attributes)
+	}
+	return nil
+}
+
+func printAttributes(attrs map[string]string) {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s=%s\n", name, attrs[name])
+	}
+}
+
+// Only the value's bytes, as the metadata service sent them, so that binary
+// user data survives.  Nothing at all is written when there is no value.
+func writeRaw(result Result) error {
+	if result.Value == nil {
+		return nil
+	}
+	_, err := os.Stdout.Write([]byte(*result.Value))
+	return err
+}
+
+func writeJSON(result Result) error {
+	out, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+// Double quotes with \, ", $ and ` escaped read back the same in sh and in
+// a systemd EnvironmentFile.
+func envQuote(value string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range value {
+		if strings.ContainsRune("\\\"$`", r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// Attribute names such as aws.zone-type become MYCLOUD_ATTR_AWS_ZONE_TYPE.
+func envName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(name))
+}
+
+func writeEnv(result Result) error {
+	fmt.Printf("MYCLOUD_NAME=%s\n", envQuote(result.Cloud))
+	fmt.Printf("MYCLOUD_ID=%s\n", envQuote(result.Id))
+	fmt.Printf("MYCLOUD_DETECTED=%t\n", result.Detected)
+	if result.Key != "" {
+		fmt.Printf("MYCLOUD_KEY=%s\n", envQuote(result.Key))
+		if result.Value != nil {
+			fmt.Printf("MYCLOUD_KEY_VALUE=%s\n", envQuote(*result.Value))
+		}
+	}
+	if result.Error != "" {
+		fmt.Printf("MYCLOUD_ERROR=%s\n", envQuote(result.Error))
+	}
+	// Several keys are named after the key, e.g. MYCLOUD_KEY_INSTANCE_ID.
+	for _, kv := range result.Keys {
+		if kv.Value != nil {
+			fmt.Printf("MYCLOUD_KEY_%s=%s\n", envName(kv.Key), envQuote(*kv.Value))
+		}
+	}
+	names := make([]string, 0, len(result.Attributes))
+	for name := range result.Attributes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("MYCLOUD_ATTR_%s=%s\n", envName(name), envQuote(result.Attributes[name]))
+	}
+	return nil
+}
+
+// With -output everything that would go to stdout goes to a temporary file
+// beside the destination instead, which is renamed over it when the run
+// succeeded, so readers see either the old or the new content in full.
+type OutputFile struct {
+	path   string
+	mode   os.FileMode
+	uid    int
+	gid    int
+	tmp    *os.File
+	stdout *os.File
+}
+
+// The owner is user[:group], by name or by number.  -1 leaves either as
+// it is.
+func parseOwner(owner string) (int, int, error) {
+	uid, gid := -1, -1
+	if owner == "" {
+		return uid, gid, nil
+	}
+	parts := strings.SplitN(owner, ":", 2)
+	if parts[0] != "" {
+		u, err := user.Lookup(parts[0])
+		if err != nil {
+			u, err = user.LookupId(parts[0])
+		}
+		if err != nil {
+			return 0, 0, errors.New("No such user " + parts[0])
+		}
+		uid, _ = strconv.Atoi(u.Uid)
+	}
+	if len(parts) == 2 && parts[1] != "" {
+		g, err := user.LookupGroup(parts[1])
+		if err != nil {
+			g, err = user.LookupGroupId(parts[1])
+		}
+		if err != nil {
+			return 0, 0, errors.New("No such group " + parts[1])
+		}
+		gid, _ = strconv.Atoi(g.Gid)
+	}
+	return uid, gid, nil
+}
+
+func openOutput(path string, mode os.FileMode, owner string) (*OutputFile, error) {
+	uid, gid, err := parseOwner(owner)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := createTemp(path)
+	if err != nil {
+		return nil, err
+	}
+	o := &OutputFile{path: path, mode: mode, uid: uid, gid: gid, tmp: tmp, stdout: os.Stdout}
+	os.Stdout = tmp
+	return o, nil
+}
+
+func (o *OutputFile) close(succeeded bool) error {
+	os.Stdout = o.stdout
+	defer os.Remove(o.tmp.Name())
+	if !succeeded {
+		o.tmp.Close()
+		return nil
+	}
+	if err := o.tmp.Chmod(o.mode); err != nil {
+		o.tmp.Close()
+		return err
+	}
+	if o.uid != -1 || o.gid != -1 {
+		if err := o.tmp.Chown(o.uid, o.gid); err != nil {
+			o.tmp.Close()
+			return err
+		}
+	}
+	return replaceWithTemp(o.tmp, o.path)
+}
@@ -0,0 +1,60 @@
+package mycloud
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// CPU flags the kernel sets in guests whose memory is encrypted by the
+// hardware, strongest first.
+var memoryEncryptionFlags = []struct {
+	flag       string
+	technology string
+}{
+	{"tdx_guest", "TDX"},
+	{"sev_snp", "SEV_SNP"},
+	{"sev_es", "SEV_ES"},
+	{"sev", "SEV"},
+}
+
+func cpuFlags() map[string]bool {
+	flags := map[string]bool{}
+	cpuinfo, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return flags
+	}
+	for _, line := range strings.Split(string(cpuinfo), "\n") {
+		if strings.HasPrefix(line, "flags") {
+			for _, flag := range strings.Fields(line) {
+				flags[flag] = true
+			}
+			break
+		}
+	}
+	return flags
+}
+
+// The confidential computing technology protecting this guest, if any.
+func memoryEncryption() string {
+	flags := cpuFlags()
+	for _, f := range memoryEncryptionFlags {
+		if flags[f.flag] {
+			return f.technology
+		}
+	}
+	return ""
+}
+
+const secureBootVariable = "/sys/firmware/efi/efivars/SecureBoot-8be4df61-93ca-11d2-aa0d-00e098032b8c"
+
+// The variable is four bytes of attributes followed by the value.
+func secureBootEnabled() bool {
+	data, err := ioutil.ReadFile(secureBootVariable)
+	return err == nil && len(data) == 5 && data[4] == 1
+}
+
+func hasTPM() bool {
+	_, err := os.Stat("/sys/class/tpm/tpm0")
+	return err == nil
+}
@@ -0,0 +1,124 @@
+package provider
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Walk a JSON document along the path, using numbers to index arrays.
+// Strings are returned as they are and anything else as JSON.
+func LookupJSON(doc string, path []string) (*string, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return nil, err
+	}
+	for _, name := range path {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			v = node[name]
+		case []interface{}:
+			i, err := strconv.Atoi(name)
+			if err != nil || i < 0 || i >= len(node) {
+				v = nil
+			} else {
+				v = node[i]
+			}
+		default:
+			v = nil
+		}
+		if v == nil {
+			return nil, errors.New("No such key " + strings.Join(path, "/"))
+		}
+	}
+	if s, ok := v.(string); ok {
+		return &s, nil
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	s := string(out)
+	return &s, nil
+}
+
+// The field names of the JSON document at dir, or the indexes of an array.
+func DocumentKeys(doc string, dir string) ([]string, error) {
+	if dir = strings.Trim(dir, "/"); dir != "" {
+		val, err := LookupJSON(doc, strings.Split(dir, "/"))
+		if err != nil {
+			return nil, err
+		}
+		doc = *val
+		dir += "/"
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(doc), &v); err != nil {
+		return nil, errors.New("Not a directory")
+	}
+	keys := []string{}
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for name, child := range node {
+			keys = append(keys, childKey(dir+name, child))
+		}
+		sort.Strings(keys)
+	case []interface{}:
+		for i, child := range node {
+			keys = append(keys, childKey(dir+strconv.Itoa(i), child))
+		}
+	default:
+		return nil, errors.New("Not a directory")
+	}
+	return keys, nil
+}
+
+func childKey(key string, child interface{}) string {
+	switch child.(type) {
+	case map[string]interface{}, []interface{}:
+		return key + "/"
+	}
+	return key
+}
+
+func ParseDocument(doc *string, err error) (interface{}, error) {
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(*doc), &v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// Zones are named after their region with a -suffix, e.g. us-central1-a.
+func RegionOfZone(zone string) string {
+	zone = path.Base(zone)
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		return zone[:i]
+	}
+	return zone
+}
+
+// Tags without values kept as a JSON list of names under one key.
+func JSONListTags(ctx context.Context, get KeyFunc, key string) (map[string]string, error) {
+	val, err := get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(*val), &names); err != nil {
+		return nil, fmt.Errorf("The tags under %s are not a JSON list: %s", key, err)
+	}
+	tags := map[string]string{}
+	for _, name := range names {
+		tags[name] = ""
+	}
+	return tags, nil
+}
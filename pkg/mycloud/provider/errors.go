@@ -0,0 +1,37 @@
+package provider
+
+import "errors"
+
+// Metadata services throttle busy hosts with a 429 (or a 503).  That still
+// proves the service is there, so callers must not treat it as a miss.
+type ThrottledError struct {
+	URL    string
+	Status string
+}
+
+func (e *ThrottledError) Error() string {
+	return "Throttled getting the url " + e.URL + " : " + e.Status
+}
+
+func IsThrottled(err error) bool {
+	var throttled *ThrottledError
+	return errors.As(err, &throttled)
+}
+
+type InvalidResponseError struct {
+	URL string
+}
+
+func (e *InvalidResponseError) Error() string {
+	return "The response from " + e.URL + " does not look like a metadata service"
+}
+
+type HTTPStatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e *HTTPStatusError) Error() string {
+	return "An error getting the url " + e.URL + " : " + e.Status
+}
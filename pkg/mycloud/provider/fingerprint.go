@@ -0,0 +1,61 @@
+package provider
+
+// The endpoints and other signatures of a cloud, from mycloud's
+// fingerprints.
+type Fingerprint struct {
+	ID        string            `json:"-"`
+	Name      string            `json:"name,omitempty"`
+	BaseUrl   string            `json:"base_url,omitempty"`
+	TestUrl   string            `json:"test_url,omitempty"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	IdPattern string            `json:"id_pattern,omitempty"`
+	Urls      map[string]string `json:"urls,omitempty"`
+	Files     []string          `json:"files,omitempty"`
+	DMI       []string          `json:"dmi,omitempty"`
+	// The hypervisors the cloud runs its instances on, by the names
+	// mycloud gives CPUID signatures: KVM, QEMU, Xen, Hyper-V, VMware,
+	// VirtualBox or bhyve.
+	Hypervisors []string `json:"hypervisors,omitempty"`
+}
+
+// Fields set in the override replace the ones in the base, maps are merged.
+func (f Fingerprint) Merge(o Fingerprint) Fingerprint {
+	if o.Name != "" {
+		f.Name = o.Name
+	}
+	if o.BaseUrl != "" {
+		f.BaseUrl = o.BaseUrl
+	}
+	if o.TestUrl != "" {
+		f.TestUrl = o.TestUrl
+	}
+	if o.IdPattern != "" {
+		f.IdPattern = o.IdPattern
+	}
+	if o.Files != nil {
+		f.Files = o.Files
+	}
+	if o.DMI != nil {
+		f.DMI = o.DMI
+	}
+	if o.Hypervisors != nil {
+		f.Hypervisors = o.Hypervisors
+	}
+	f.Headers = MergeStrings(f.Headers, o.Headers)
+	f.Urls = MergeStrings(f.Urls, o.Urls)
+	return f
+}
+
+func MergeStrings(a map[string]string, b map[string]string) map[string]string {
+	if len(b) == 0 {
+		return a
+	}
+	m := map[string]string{}
+	for k, v := range a {
+		m[k] = v
+	}
+	for k, v := range b {
+		m[k] = v
+	}
+	return m
+}
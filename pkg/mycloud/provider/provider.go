@@ -0,0 +1,101 @@
+// Package provider is what a cloud provider package implements to be
+// detected by mycloud, and the helpers mycloud lends it to do so.  The
+// built in providers live in the packages under providers.
+package provider
+
+import "context"
+
+// A cloud detector from outside the mycloud package, e.g. for a private
+// cloud.
+type Detector interface {
+	// The name printed when the cloud is detected.
+	Name() string
+	// Whether the program runs in the cloud.  An error says why a cloud
+	// that looked likely could not be confirmed, and is shown when no
+	// cloud is found.  A throttled metadata service, see IsThrottled, may
+	// still be the cloud's, so it is returned with true, and counts as
+	// ConfidenceLow since its answer could not be checked.
+	Detect(ctx context.Context) (bool, error)
+	// The value of a metadata key.
+	Get(ctx context.Context, key string) (string, error)
+}
+
+// Detectors whose metadata service has user data.
+type UserDataSource interface {
+	UserData(ctx context.Context) (string, error)
+}
+
+// Detectors whose metadata service has vendor data.
+type VendorDataSource interface {
+	VendorData(ctx context.Context) (string, error)
+}
+
+// Detectors whose metadata service hands out the SSH public keys of the
+// instance, in authorized_keys format.
+type SSHKeySource interface {
+	SSHKeys(ctx context.Context) ([]string, error)
+}
+
+// Detectors that know the account, subscription or project that owns the
+// instance.
+type AccountIdentifier interface {
+	AccountID(ctx context.Context) (string, error)
+}
+
+// Detectors that can list the keys under dir, with a trailing / on the
+// names that are directories.
+type KeyLister interface {
+	ListKeys(ctx context.Context, dir string) ([]string, error)
+}
+
+// Detectors that can fetch all of their metadata as one document.
+type Dumper interface {
+	Dump(ctx context.Context) (interface{}, error)
+}
+
+// A summary field, such as instance_id, and the metadata key it comes
+// from.  Transform, when set, rewrites the value.
+type SummaryField struct {
+	Name      string
+	Key       string
+	Transform func(string) string
+}
+
+// Detectors that can fill in the summary and the tags.
+type Summarizer interface {
+	SummaryFields() []SummaryField
+	// The tags of the instance, nil where the cloud has none, or why
+	// they could not be had.
+	Tags(ctx context.Context) (map[string]string, error)
+}
+
+// How a provider reaches its metadata service.  mycloud hands every
+// provider one that sends the requests the way its own are sent, with
+// the retries, the options of Detect and the verbose log.
+type Client interface {
+	Get(ctx context.Context, url string, headers map[string]string) (*string, error)
+	Send(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error)
+}
+
+// Gets the value of a metadata key, such as a provider's own getter.
+type KeyFunc func(ctx context.Context, key string) (*string, error)
+
+// How sure a detector is that the program runs in its cloud.  When
+// several detectors match, the most confident one is reported and the
+// order they are tried in only breaks ties.
+const (
+	// A hypervisor or bare metal, which says nothing about the cloud, or
+	// a metadata service that only throttled the probe.
+	ConfidenceLow = 10
+	// Something answered the way a family of clouds does, such as any
+	// EC2 compatible metadata service or a NoCloud seed.
+	ConfidenceMedium = 50
+	// The cloud's own metadata service, headers or identity answered.
+	ConfidenceHigh = 90
+)
+
+// Detectors that know how sure they are.  Those that do not implement it
+// are taken as ConfidenceHigh when they match.
+type Scorer interface {
+	Confidence() int
+}
@@ -0,0 +1,102 @@
+package provider
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// Answers from a map of url to document, and with a 404 otherwise.
+type fakeClient map[string]string
+
+func (c fakeClient) Get(ctx context.Context, url string, headers map[string]string) (*string, error) {
+	doc, ok := c[url]
+	if !ok {
+		return nil, &HTTPStatusError{URL: url, Status: "404 Not Found", StatusCode: 404}
+	}
+	return &doc, nil
+}
+
+func (c fakeClient) Send(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error) {
+	return c.Get(ctx, url, headers)
+}
+
+func TestIsThrottled(t *testing.T) {
+	throttled := &ThrottledError{URL: "http://169.254.169.254/", Status: "429 Too Many Requests"}
+	if !IsThrottled(throttled) {
+		t.Error("a ThrottledError is not throttled")
+	}
+	if !IsThrottled(fmt.Errorf("Detecting: %w", throttled)) {
+		t.Error("a wrapped ThrottledError is not throttled")
+	}
+	if IsThrottled(&HTTPStatusError{Status: "404 Not Found", StatusCode: 404}) {
+		t.Error("a 404 is throttled")
+	}
+}
+
+func TestResourceKey(t *testing.T) {
+	client := fakeClient{
+		"http://md/v1/instance?v=1":      `{"id": "i-1", "zone": {"name": "us-south-1"}, "tags": ["a", "b"]}`,
+		"http://md/v1/instance/keys?v=1": `[{"name": "k1"}]`,
+	}
+	get := func(ctx context.Context, key string) (*string, error) {
+		return ResourceKey(ctx, client, "http://md/v1/", "?v=1", nil, key)
+	}
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"instance/id", "i-1"},
+		{"instance/zone/name", "us-south-1"},
+		{"instance/zone", `{"name":"us-south-1"}`},
+		{"instance/tags/1", "b"},
+		// The longest resource path is tried first.
+		{"instance/keys/0/name", "k1"},
+	}
+	for _, test := range tests {
+		val, err := get(context.Background(), test.key)
+		if err != nil || *val != test.want {
+			t.Errorf("%s: got %v, %v", test.key, val, err)
+		}
+	}
+	if _, err := get(context.Background(), "instance/missing"); err == nil {
+		t.Error("a missing key was found")
+	}
+	if _, err := get(context.Background(), "network"); err == nil {
+		t.Error("a missing resource was found")
+	}
+
+	keys, err := ResourceKeys(context.Background(), get, []string{"instance", "network"}, "")
+	if err != nil || !reflect.DeepEqual(keys, []string{"instance/", "network/"}) {
+		t.Errorf("the top level is %v, %v", keys, err)
+	}
+	keys, err = ResourceKeys(context.Background(), get, []string{"instance"}, "instance/")
+	if err != nil || !reflect.DeepEqual(keys, []string{"instance/id", "instance/tags/", "instance/zone/"}) {
+		t.Errorf("instance/ is %v, %v", keys, err)
+	}
+	tags, err := JSONListTags(context.Background(), get, "instance/tags")
+	if err != nil || !reflect.DeepEqual(tags, map[string]string{"a": "", "b": ""}) {
+		t.Errorf("the tags are %v, %v", tags, err)
+	}
+}
+
+func TestFingerprintMerge(t *testing.T) {
+	base := Fingerprint{Name: "Acme", BaseUrl: "http://md/", Headers: map[string]string{"A": "1"}, DMI: []string{"Acme"}}
+	merged := base.Merge(Fingerprint{TestUrl: "http://md/id", Headers: map[string]string{"B": "2"}})
+	want := Fingerprint{Name: "Acme", BaseUrl: "http://md/", TestUrl: "http://md/id", Headers: map[string]string{"A": "1", "B": "2"}, DMI: []string{"Acme"}}
+	if !reflect.DeepEqual(merged, want) {
+		t.Errorf("merged into %+v", merged)
+	}
+	if len(base.Headers) != 1 {
+		t.Error("the base headers were changed")
+	}
+}
+
+func TestRegionOfZone(t *testing.T) {
+	for zone, region := range map[string]string{"us-south-1": "us-south", "projects/1/zones/us-central1-a": "us-central1", "local": "local"} {
+		if got := RegionOfZone(zone); got != region {
+			t.Errorf("%s: got %s", zone, got)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package provider
+
+import (
+	"context"
+	"errors"
+	"strings"
+)
+
+// Some metadata services are made of JSON resources, such as instance or
+// network, rather than a tree of values.  A key names a resource followed by
+// a path into its document, so try the longest resource path first.
+func ResourceKey(ctx context.Context, client Client, baseUrl string, query string, headers map[string]string, key string) (*string, error) {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	var lastErr error
+	for i := len(parts); i > 0; i-- {
+		url := baseUrl + strings.Join(parts[:i], "/") + query
+		doc, err := client.Get(ctx, url, headers)
+		if err != nil {
+			lastErr = err
+			var status *HTTPStatusError
+			if errors.As(err, &status) {
+				continue
+			}
+			return nil, err
+		}
+		return LookupJSON(*doc, parts[i:])
+	}
+	return nil, lastErr
+}
+
+// Resource based services have no listing of their resources, so the top
+// level is the resources the provider knows of.
+func ResourceKeys(ctx context.Context, get KeyFunc, resources []string, dir string) ([]string, error) {
+	if strings.Trim(dir, "/") == "" {
+		keys := make([]string, len(resources))
+		for i, name := range resources {
+			keys[i] = name + "/"
+		}
+		return keys, nil
+	}
+	parts := strings.SplitN(strings.Trim(dir, "/"), "/", 2)
+	doc, err := get(ctx, parts[0])
+	if err != nil {
+		return nil, err
+	}
+	keys, err := DocumentKeys(*doc, strings.TrimPrefix(strings.Trim(dir, "/"), parts[0]))
+	for i := range keys {
+		keys[i] = parts[0] + "/" + keys[i]
+	}
+	return keys, err
+}
+
+// Resource based services have no listing, so the known resources are
+// fetched one by one.
+func DumpResources(ctx context.Context, get KeyFunc, names []string) (interface{}, error) {
+	out := map[string]interface{}{}
+	for _, name := range names {
+		v, err := ParseDocument(get(ctx, name))
+		if err != nil {
+			return nil, err
+		}
+		out[name] = v
+	}
+	return out, nil
+}
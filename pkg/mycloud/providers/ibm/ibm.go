@@ -0,0 +1,117 @@
+// Package ibm detects IBM Cloud VPC instances through their metadata
+// service.
+package ibm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// IBM Cloud VPC
+/////////////////////////////////////////////////////////
+const apiVersion = "2022-03-01"
+
+type Cloud struct {
+	fingerprint provider.Fingerprint
+	client      provider.Client
+	headers     map[string]string
+}
+
+func New(fp provider.Fingerprint, client provider.Client) *Cloud {
+	return &Cloud{fingerprint: fp, client: client}
+}
+
+func (c *Cloud) Name() string {
+	return c.fingerprint.Name
+}
+
+// Every request needs an access token from the instance identity service,
+// which is only reachable when the metadata service is enabled for the
+// instance.
+func (c *Cloud) Detect(ctx context.Context) (bool, error) {
+	url := c.fingerprint.Urls["token"] + "?version=" + apiVersion
+	headers := provider.MergeStrings(c.fingerprint.Headers, map[string]string{"Content-Type": "application/json"})
+	doc, err := c.client.Send(ctx, "PUT", url, headers, `{"expires_in": 3600}`)
+	if err != nil {
+		return false, err
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+	}
+	if json.Unmarshal([]byte(*doc), &token) != nil || token.AccessToken == "" {
+		return false, &provider.InvalidResponseError{URL: url}
+	}
+	c.headers = map[string]string{"Authorization": "Bearer " + token.AccessToken}
+
+	instance, err := c.getKey(ctx, "instance/crn")
+	if err == nil && !strings.HasPrefix(*instance, "crn:v1:bluemix:") {
+		err = &provider.InvalidResponseError{URL: c.fingerprint.TestUrl}
+	}
+	return err == nil || provider.IsThrottled(err), err
+}
+
+// Keys are a metadata resource followed by a path into its document, e.g.
+// instance/profile/name.
+func (c *Cloud) Get(ctx context.Context, key string) (string, error) {
+	val, err := c.getKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return *val, nil
+}
+
+func (c *Cloud) getKey(ctx context.Context, key string) (*string, error) {
+	return provider.ResourceKey(ctx, c.client, c.fingerprint.BaseUrl, "?version="+apiVersion, c.headers, key)
+}
+
+func (c *Cloud) UserData(ctx context.Context) (string, error) {
+	return c.Get(ctx, "instance/initialization/user_data")
+}
+
+// The public keys the instance was created with.
+func (c *Cloud) SSHKeys(ctx context.Context) ([]string, error) {
+	doc, err := c.getKey(ctx, "keys")
+	if err != nil {
+		return nil, err
+	}
+	var keys struct {
+		Keys []struct {
+			PublicKey string `json:"public_key"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &keys); err != nil {
+		return nil, err
+	}
+	var all []string
+	for _, key := range keys.Keys {
+		all = append(all, key.PublicKey)
+	}
+	return all, nil
+}
+
+func (c *Cloud) ListKeys(ctx context.Context, dir string) ([]string, error) {
+	return provider.ResourceKeys(ctx, c.getKey, []string{"instance"}, dir)
+}
+
+func (c *Cloud) Dump(ctx context.Context) (interface{}, error) {
+	return provider.DumpResources(ctx, c.getKey, []string{"instance"})
+}
+
+func (c *Cloud) SummaryFields() []provider.SummaryField {
+	return []provider.SummaryField{
+		{Name: "instance_id", Key: "instance/id"},
+		{Name: "instance_type", Key: "instance/profile/name"},
+		{Name: "region", Key: "instance/zone/name", Transform: provider.RegionOfZone},
+		{Name: "zone", Key: "instance/zone/name"},
+		{Name: "private_ip", Key: "instance/primary_network_interface/primary_ip/address"},
+		{Name: "hostname", Key: "instance/name"},
+	}
+}
+
+func (c *Cloud) Tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
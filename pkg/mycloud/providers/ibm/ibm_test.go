@@ -0,0 +1,68 @@
+package ibm
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+var fingerprint = provider.Fingerprint{
+	Name:    "IBM Cloud",
+	BaseUrl: "http://md/metadata/v1/",
+	TestUrl: "http://md/metadata/v1/instance",
+	Headers: map[string]string{"Metadata-Flavor": "ibm"},
+	Urls:    map[string]string{"token": "http://md/instance_identity/v1/token"},
+}
+
+// Answers from a map of method and url to document, when the request has
+// the token.
+type fakeClient map[string]string
+
+func (c fakeClient) Get(ctx context.Context, url string, headers map[string]string) (*string, error) {
+	if headers["Authorization"] != "Bearer t0k3n" {
+		return nil, &provider.HTTPStatusError{URL: url, Status: "401 Unauthorized", StatusCode: 401}
+	}
+	return c.Send(ctx, "GET", url, headers, "")
+}
+
+func (c fakeClient) Send(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error) {
+	doc, ok := c[method+" "+url]
+	if !ok {
+		return nil, &provider.HTTPStatusError{URL: url, Status: "404 Not Found", StatusCode: 404}
+	}
+	return &doc, nil
+}
+
+func ibm(crn string) fakeClient {
+	return fakeClient{
+		"PUT http://md/instance_identity/v1/token?version=" + apiVersion: `{"access_token": "t0k3n"}`,
+		"GET http://md/metadata/v1/instance?version=" + apiVersion:       `{"id": "0717_1e09", "crn": "` + crn + `", "name": "web", "zone": {"name": "us-south-1"}, "profile": {"name": "bx2-2x8"}}`,
+		"GET http://md/metadata/v1/keys?version=" + apiVersion:           `{"keys": [{"name": "admin", "public_key": "ssh-ed25519 AAAA admin"}]}`,
+	}
+}
+
+func TestDetect(t *testing.T) {
+	c := New(fingerprint, ibm("crn:v1:bluemix:public:is:us-south-1:a/123::instance:0717_1e09"))
+	found, err := c.Detect(context.Background())
+	if !found || err != nil {
+		t.Fatalf("Detect returned %v, %v", found, err)
+	}
+	if profile, err := c.Get(context.Background(), "instance/profile/name"); err != nil || profile != "bx2-2x8" {
+		t.Errorf("the profile is %q, %v", profile, err)
+	}
+	if zone, err := c.Get(context.Background(), "instance/zone/name"); err != nil || provider.RegionOfZone(zone) != "us-south" {
+		t.Errorf("the zone is %q, %v", zone, err)
+	}
+	keys, err := c.SSHKeys(context.Background())
+	if err != nil || !reflect.DeepEqual(keys, []string{"ssh-ed25519 AAAA admin"}) {
+		t.Errorf("the SSH keys are %v, %v", keys, err)
+	}
+
+	other := New(fingerprint, ibm("arn:aws:ec2:us-east-1:123:instance/i-1"))
+	found, err = other.Detect(context.Background())
+	if _, invalid := err.(*provider.InvalidResponseError); found || !invalid {
+		t.Errorf("another service detected as %v, %v", found, err)
+	}
+}
@@ -0,0 +1,127 @@
+// Package linode detects Linode (Akamai) instances through their metadata
+// service.
+package linode
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Linode (Akamai)
+/////////////////////////////////////////////////////////
+type Cloud struct {
+	fingerprint provider.Fingerprint
+	client      provider.Client
+	headers     map[string]string
+}
+
+func New(fp provider.Fingerprint, client provider.Client) *Cloud {
+	return &Cloud{fingerprint: fp, client: client}
+}
+
+func (c *Cloud) Name() string {
+	return c.fingerprint.Name
+}
+
+// The metadata service only answers requests carrying a token, which is
+// exchanged for with a PUT.
+func (c *Cloud) Detect(ctx context.Context) (bool, error) {
+	headers := map[string]string{"Metadata-Token-Expiry-Seconds": "3600"}
+	token, err := c.client.Send(ctx, "PUT", c.fingerprint.Urls["token"], headers, "")
+	if err != nil {
+		return false, err
+	}
+	c.headers = provider.MergeStrings(c.fingerprint.Headers, map[string]string{"Metadata-Token": strings.TrimSpace(*token)})
+
+	doc, err := c.client.Get(ctx, c.fingerprint.TestUrl, c.headers)
+	if err == nil {
+		var instance struct {
+			Id     int    `json:"id"`
+			Region string `json:"region"`
+		}
+		if json.Unmarshal([]byte(*doc), &instance) != nil || instance.Id == 0 || instance.Region == "" {
+			err = &provider.InvalidResponseError{URL: c.fingerprint.TestUrl}
+		}
+	}
+	return err == nil || provider.IsThrottled(err), err
+}
+
+// Keys are a resource (instance, network, ssh-keys) followed by a path into
+// its document, e.g. instance/specs/memory.
+func (c *Cloud) Get(ctx context.Context, key string) (string, error) {
+	val, err := c.getKey(ctx, key)
+	if err != nil {
+		return "", err
+	}
+	return *val, nil
+}
+
+func (c *Cloud) getKey(ctx context.Context, key string) (*string, error) {
+	return provider.ResourceKey(ctx, c.client, c.fingerprint.BaseUrl, "", c.headers, key)
+}
+
+func (c *Cloud) UserData(ctx context.Context) (string, error) {
+	val, err := c.client.Get(ctx, c.fingerprint.BaseUrl+"user-data", c.headers)
+	if err != nil {
+		return "", err
+	}
+	return *val, nil
+}
+
+// The keys of every user the instance was deployed with, root's among
+// them.
+func (c *Cloud) SSHKeys(ctx context.Context) ([]string, error) {
+	doc, err := c.getKey(ctx, "ssh-keys")
+	if err != nil {
+		return nil, err
+	}
+	var keys struct {
+		Users map[string][]string `json:"users"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &keys); err != nil {
+		return nil, err
+	}
+	users := make([]string, 0, len(keys.Users))
+	for user := range keys.Users {
+		users = append(users, user)
+	}
+	sort.Strings(users)
+	var all []string
+	for _, user := range users {
+		all = append(all, keys.Users[user]...)
+	}
+	return all, nil
+}
+
+func (c *Cloud) ListKeys(ctx context.Context, dir string) ([]string, error) {
+	return provider.ResourceKeys(ctx, c.getKey, []string{"instance", "network"}, dir)
+}
+
+func (c *Cloud) Dump(ctx context.Context) (interface{}, error) {
+	return provider.DumpResources(ctx, c.getKey, []string{"instance", "network"})
+}
+
+// Addresses are listed with their prefix length.
+func withoutPrefixLength(address string) string {
+	return strings.SplitN(address, "/", 2)[0]
+}
+
+func (c *Cloud) SummaryFields() []provider.SummaryField {
+	return []provider.SummaryField{
+		{Name: "instance_id", Key: "instance/id"},
+		{Name: "instance_type", Key: "instance/type"},
+		{Name: "region", Key: "instance/region"},
+		{Name: "private_ip", Key: "network/ipv4/private/0", Transform: withoutPrefixLength},
+		{Name: "public_ip", Key: "network/ipv4/public/0", Transform: withoutPrefixLength},
+		{Name: "hostname", Key: "instance/label"},
+	}
+}
+
+func (c *Cloud) Tags(ctx context.Context) (map[string]string, error) {
+	return provider.JSONListTags(ctx, c.getKey, "instance/tags")
+}
@@ -0,0 +1,95 @@
+package linode
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+var fingerprint = provider.Fingerprint{
+	Name:    "Linode",
+	BaseUrl: "http://md/v1/",
+	TestUrl: "http://md/v1/instance",
+	Headers: map[string]string{"Accept": "application/json"},
+	Urls:    map[string]string{"token": "http://md/v1/token"},
+}
+
+// Answers from a map of method and url to document, when the request has
+// the token.
+type fakeClient map[string]string
+
+func (c fakeClient) Get(ctx context.Context, url string, headers map[string]string) (*string, error) {
+	if headers["Metadata-Token"] != "t0k3n" {
+		return nil, &provider.HTTPStatusError{URL: url, Status: "401 Unauthorized", StatusCode: 401}
+	}
+	return c.Send(ctx, "GET", url, headers, "")
+}
+
+func (c fakeClient) Send(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error) {
+	doc, ok := c[method+" "+url]
+	if !ok {
+		return nil, &provider.HTTPStatusError{URL: url, Status: "404 Not Found", StatusCode: 404}
+	}
+	return &doc, nil
+}
+
+func linode(instance string) fakeClient {
+	return fakeClient{
+		"PUT http://md/v1/token":     "t0k3n\n",
+		"GET http://md/v1/instance":  instance,
+		"GET http://md/v1/ssh-keys":  `{"users": {"root": ["ssh-ed25519 AAAA root"], "deploy": ["ssh-rsa BBBB deploy"]}}`,
+		"GET http://md/v1/network":   `{"ipv4": {"public": ["172.105.1.2/24"], "private": []}}`,
+		"GET http://md/v1/user-data": "I2Nsb3VkLWNvbmZpZw==",
+	}
+}
+
+func TestDetect(t *testing.T) {
+	c := New(fingerprint, linode(`{"id": 1234, "label": "web", "region": "us-east", "tags": ["prod"]}`))
+	found, err := c.Detect(context.Background())
+	if !found || err != nil {
+		t.Fatalf("Detect returned %v, %v", found, err)
+	}
+	if region, err := c.Get(context.Background(), "instance/region"); err != nil || region != "us-east" {
+		t.Errorf("the region is %q, %v", region, err)
+	}
+	if ip, err := c.Get(context.Background(), "network/ipv4/public/0"); err != nil || withoutPrefixLength(ip) != "172.105.1.2" {
+		t.Errorf("the public address is %q, %v", ip, err)
+	}
+	keys, err := c.SSHKeys(context.Background())
+	if want := []string{"ssh-rsa BBBB deploy", "ssh-ed25519 AAAA root"}; err != nil || !reflect.DeepEqual(keys, want) {
+		t.Errorf("the SSH keys are %v, %v", keys, err)
+	}
+	tags, err := c.Tags(context.Background())
+	if err != nil || !reflect.DeepEqual(tags, map[string]string{"prod": ""}) {
+		t.Errorf("the tags are %v, %v", tags, err)
+	}
+
+	other := New(fingerprint, linode(`{"instance_id": "i-1"}`))
+	found, err = other.Detect(context.Background())
+	if _, invalid := err.(*provider.InvalidResponseError); found || !invalid {
+		t.Errorf("another service detected as %v, %v", found, err)
+	}
+}
+
+// A service that throttles the instance document is still there.
+func TestDetectThrottled(t *testing.T) {
+	c := New(fingerprint, throttledClient{})
+	found, err := c.Detect(context.Background())
+	if !found || !provider.IsThrottled(err) {
+		t.Errorf("Detect returned %v, %v", found, err)
+	}
+}
+
+// Hands out a token and throttles everything else.
+type throttledClient struct{}
+
+func (throttledClient) Get(ctx context.Context, url string, headers map[string]string) (*string, error) {
+	return nil, &provider.ThrottledError{URL: url, Status: "429 Too Many Requests"}
+}
+
+func (c throttledClient) Send(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error) {
+	token := "t0k3n"
+	return &token, nil
+}
@@ -0,0 +1,50 @@
+package mycloud
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////
+// Proxmox VE
+/////////////////////////////////////////////////////////
+type ProxmoxCloud struct {
+	BaseCloud
+	seed *seedDrive
+}
+
+// Proxmox has no metadata server.  It hands cloud-init settings to the
+// guest on a NoCloud or ConfigDrive seed drive and names the instance
+// with a SHA-1 of the settings, which is what tells it from other seeds.
+func NewProxmoxCloud() ProxmoxCloud {
+	c := ProxmoxCloud{}
+	c.fingerprint = fingerprintFor("proxmox")
+	c.supportsKey = true
+	c.name = c.fingerprint.Name
+	return c
+}
+
+func (c *ProxmoxCloud) detectEffectiveCloud(ctx context.Context) {
+	c.isMyCloud = false
+	if dmi := readDMI(); len(dmi) > 0 && dmiHypervisor(dmi) != "KVM" && !dmiMatches(dmi, c.fingerprint.DMI) {
+		return
+	}
+	c.seed = findSeedDrive(c.fingerprint.Files)
+	if c.seed == nil {
+		return
+	}
+	id, err := c.seed.instanceId()
+	if err != nil {
+		return
+	}
+	matched, err := regexp.MatchString(c.fingerprint.IdPattern, strings.TrimSpace(*id))
+	c.isMyCloud = err == nil && matched
+	c.signal = signalFiles
+}
+
+// Keys are looked up in the seed's meta-data, e.g. instance-id or
+// local-hostname.
+func (c *ProxmoxCloud) getKey(ctx context.Context, key string) (*string, error) {
+	return c.seed.metadataKey(key)
+}
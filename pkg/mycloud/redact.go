@@ -0,0 +1,73 @@
+package mycloud
+
+import (
+	"regexp"
+	"strings"
+)
+
+/////////////////////////////////////////////////////////
+// Redaction of the verbose log
+/////////////////////////////////////////////////////////
+// Values of keys whose names match one of these are never logged.  -redact
+// adds patterns of its own.
+var sensitiveKeyPatterns = []string{"password", "passwd", "token", "key", "secret", "credential", "authorization", "signature", "jwt", "pkcs7"}
+
+// The responses of these are credentials, tokens or secrets as a whole,
+// including user data which often carries them.
+var credentialUrlPattern = regexp.MustCompile(`(?i)security-credentials|/token\b|/identity\b|/attested/|/auth/[^?]*/login|://(ssm|secretsmanager|secretmanager)\.|/secrets/|user-?data|vendor-?data|vendor_data`)
+
+// Whatever looks like a bearer token, a JWT or an AWS access key id is
+// redacted wherever it appears.
+var credentialValuePattern = regexp.MustCompile(`(?i)(bearer\s+)[\w.~+/-]+=*|\beyJ[\w-]+\.[\w-]+\.[\w-]*|\b(AKIA|ASIA)[A-Z0-9]{16}\b`)
+
+var sensitiveJSONPattern, sensitiveAssignmentPattern *regexp.Regexp
+
+func init() {
+	if err := setRedactPatterns(nil); err != nil {
+		panic(err)
+	}
+}
+
+// Compile the redaction of the sensitive key patterns plus extra, which
+// are regular expressions.
+func setRedactPatterns(extra []string) error {
+	for _, pattern := range extra {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return err
+		}
+	}
+	names := strings.Join(append(append([]string{}, sensitiveKeyPatterns...), extra...), "|")
+	jsonPattern, err := regexp.Compile(`(?i)("[^"]*(?:` + names + `)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"`)
+	if err != nil {
+		return err
+	}
+	assignmentPattern, err := regexp.Compile(`(?i)([\w.-]*(?:` + names + `)[\w.-]*\s*[=:]\s*)[^\s&",;]+`)
+	if err != nil {
+		return err
+	}
+	sensitiveJSONPattern, sensitiveAssignmentPattern = jsonPattern, assignmentPattern
+	return nil
+}
+
+func redact(message string) string {
+	message = sensitiveJSONPattern.ReplaceAllString(message, `$1"<redacted>"`)
+	message = sensitiveAssignmentPattern.ReplaceAllString(message, `$1<redacted>`)
+	return credentialValuePattern.ReplaceAllStringFunc(message, func(value string) string {
+		if m := credentialValuePattern.FindStringSubmatch(value); m[1] != "" {
+			return m[1] + "<redacted>"
+		}
+		return "<redacted>"
+	})
+}
+
+// The body of a response, as the verbose log shows it: nothing of
+// credential endpoints and the start of anything else.
+func loggedBody(url string, body string) string {
+	if credentialUrlPattern.MatchString(url) {
+		return "<redacted>"
+	}
+	if len(body) > 200 {
+		body = body[:200] + "..."
+	}
+	return strings.Replace(body, "\n", " ", -1)
+}
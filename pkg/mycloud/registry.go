@@ -0,0 +1,247 @@
+package mycloud
+
+import (
+	"context"
+	"sync"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Detector registry
+/////////////////////////////////////////////////////////
+// A cloud detector from outside this package, e.g. for a private cloud.
+type Detector = provider.Detector
+
+// Makes a new Detector for every detection.
+type Factory func() Detector
+
+type registration struct {
+	id      string
+	factory func() CloudDetector
+	// Hypervisors and bare metal tell nothing about the cloud and are
+	// only reported when no cloud is found.
+	fallback bool
+}
+
+var registryLock sync.Mutex
+var registry []registration
+
+func register(id string, fallback bool, factory func() CloudDetector) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for i, r := range registry {
+		if r.id == id {
+			registry[i].factory = factory
+			return
+		}
+	}
+	registry = append(registry, registration{id, factory, fallback})
+}
+
+// Add a detector under id, which replaces the built in one of the same id.
+// Detectors are tried in the order they were registered, after the built
+// in clouds and before the hypervisors and bare metal.  It is meant to be
+// called from an init function.
+func Register(id string, factory Factory) {
+	register(id, false, func() CloudDetector {
+		d := factory()
+		c := &registeredCloud{detector: d}
+		c.name = d.Name()
+		c.supportsKey = true
+		c.fingerprint = Fingerprint{ID: id, Name: d.Name()}
+		return c
+	})
+}
+
+// Add a built in provider, which is made with its fingerprint and a client
+// that sends its requests the way the clouds of this package send theirs.
+func registerProvider(id string, newProvider func(provider.Fingerprint, provider.Client) Detector) {
+	register(id, false, func() CloudDetector {
+		c := &registeredCloud{builtin: true}
+		c.fingerprint = fingerprintFor(id)
+		c.detector = newProvider(c.fingerprint, metadataClient{})
+		c.name = c.fingerprint.Name
+		c.supportsKey = true
+		return c
+	})
+}
+
+// The registered detectors in the order they are tried.
+func registeredClouds() []CloudDetector {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	var clouds, fallbacks []CloudDetector
+	for _, r := range registry {
+		if r.fallback {
+			fallbacks = append(fallbacks, r.factory())
+		} else {
+			clouds = append(clouds, r.factory())
+		}
+	}
+	return append(clouds, fallbacks...)
+}
+
+type registeredCloud struct {
+	BaseCloud
+	detector Detector
+	// Built in providers keep why a probe failed to the probe error, as
+	// the other built in clouds do, rather than show it as a diagnostic.
+	builtin bool
+}
+
+func (c *registeredCloud) detectEffectiveCloud(ctx context.Context) {
+	found, err := c.detector.Detect(ctx)
+	c.isMyCloud = found
+	c.throttled = found && provider.IsThrottled(err)
+	c.probeError = err
+	if err != nil && !c.builtin && !provider.IsThrottled(err) {
+		c.diagnostic = err.Error()
+	}
+}
+
+func (c *registeredCloud) cloudConfidence() int {
+	if scorer, ok := c.detector.(provider.Scorer); ok && c.isMyCloud && !c.throttled {
+		return scorer.Confidence() + signalWeight(c.signal) + cpuidConfidence(c.fingerprint)
+	}
+	return c.BaseCloud.cloudConfidence()
+}
+
+func (c *registeredCloud) getKey(ctx context.Context, key string) (*string, error) {
+	val, err := c.detector.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
+// The optional features forward to the detector when it has them.  The
+// commands look them up with userDataSourceOf and the like, since a
+// registeredCloud has every method.
+
+func (c *registeredCloud) userData(ctx context.Context) (*string, error) {
+	val, err := c.detector.(provider.UserDataSource).UserData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
+func (c *registeredCloud) vendorData(ctx context.Context) (*string, error) {
+	val, err := c.detector.(provider.VendorDataSource).VendorData(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &val, nil
+}
+
+func (c *registeredCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return c.detector.(provider.SSHKeySource).SSHKeys(ctx)
+}
+
+func (c *registeredCloud) accountId(ctx context.Context) (string, error) {
+	return c.detector.(provider.AccountIdentifier).AccountID(ctx)
+}
+
+func (c *registeredCloud) listKeys(ctx context.Context, dir string) ([]string, error) {
+	return c.detector.(provider.KeyLister).ListKeys(ctx, dir)
+}
+
+func (c *registeredCloud) dump(ctx context.Context) (interface{}, error) {
+	return c.detector.(provider.Dumper).Dump(ctx)
+}
+
+func (c *registeredCloud) summaryFields() []summaryField {
+	var fields []summaryField
+	for _, f := range c.detector.(provider.Summarizer).SummaryFields() {
+		fields = append(fields, summaryField{f.Name, f.Key, f.Transform})
+	}
+	return fields
+}
+
+func (c *registeredCloud) tags(ctx context.Context) (map[string]string, error) {
+	return c.detector.(provider.Summarizer).Tags(ctx)
+}
+
+func userDataSourceOf(cd CloudDetector) (UserDataSource, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.UserDataSource); !ok {
+			return nil, false
+		}
+	}
+	source, ok := cd.(UserDataSource)
+	return source, ok
+}
+
+func vendorDataSourceOf(cd CloudDetector) (VendorDataSource, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.VendorDataSource); !ok {
+			return nil, false
+		}
+	}
+	source, ok := cd.(VendorDataSource)
+	return source, ok
+}
+
+func sshKeySourceOf(cd CloudDetector) (SSHKeySource, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.SSHKeySource); !ok {
+			return nil, false
+		}
+	}
+	source, ok := cd.(SSHKeySource)
+	return source, ok
+}
+
+func accountIdentifierOf(cd CloudDetector) (AccountIdentifier, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.AccountIdentifier); !ok {
+			return nil, false
+		}
+	}
+	identifier, ok := cd.(AccountIdentifier)
+	return identifier, ok
+}
+
+func keyListerOf(cd CloudDetector) (KeyLister, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.KeyLister); !ok {
+			return nil, false
+		}
+	}
+	lister, ok := cd.(KeyLister)
+	return lister, ok
+}
+
+func dumperOf(cd CloudDetector) (Dumper, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.Dumper); !ok {
+			return nil, false
+		}
+	}
+	dumper, ok := cd.(Dumper)
+	return dumper, ok
+}
+
+func summarizerOf(cd CloudDetector) (Summarizer, bool) {
+	if r, ok := cd.(*registeredCloud); ok {
+		if _, ok := r.detector.(provider.Summarizer); !ok {
+			return nil, false
+		}
+	}
+	summarizer, ok := cd.(Summarizer)
+	return summarizer, ok
+}
+
+// Sends the requests of the provider packages with getUrl and sendUrl.
+type metadataClient struct{}
+
+func (metadataClient) Get(ctx context.Context, url string, headers map[string]string) (*string, error) {
+	val, _, err := getUrl(ctx, url, headers)
+	return val, err
+}
+
+func (metadataClient) Send(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error) {
+	val, _, err := sendUrl(ctx, method, url, headers, body)
+	return val, err
+}
@@ -0,0 +1,74 @@
+package mycloud
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// How metadata requests are retried when the service says it is busy,
+// with a 429 or a 5xx, or does not answer in time.  Any other answer, a
+// 404 included, is final.
+type retryPolicy struct {
+	count   int
+	backoff time.Duration
+	// The fraction of each wait that is random, so that machines booted
+	// together do not retry together.
+	jitter float64
+	// Where most clouds never answer, as while detecting, a timeout says
+	// more about the cloud than about the metadata service.
+	timeouts bool
+}
+
+var defaultRetryPolicy = retryPolicy{count: 3, backoff: 100 * time.Millisecond, jitter: 0.2, timeouts: true}
+
+// A Retry-After longer than this is not waited out.
+const maxRetryWait = 2 * time.Second
+
+var jitterMutex sync.Mutex
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// How long to wait before retrying, preferring the server's Retry-After.
+// resp is nil after a timeout.
+func (p retryPolicy) wait(resp *http.Response, attempt int) time.Duration {
+	if resp != nil {
+		retryAfter := resp.Header.Get("Retry-After")
+		if seconds, err := strconv.Atoi(retryAfter); err == nil {
+			return capRetryWait(time.Duration(seconds) * time.Second)
+		} else if when, err := http.ParseTime(retryAfter); err == nil {
+			return capRetryWait(time.Until(when))
+		}
+	}
+	wait := p.backoff << uint(attempt)
+	if p.jitter > 0 {
+		jitterMutex.Lock()
+		r := jitterRand.Float64()
+		jitterMutex.Unlock()
+		wait += time.Duration(float64(wait) * p.jitter * (2*r - 1))
+	}
+	return wait
+}
+
+func capRetryWait(wait time.Duration) time.Duration {
+	if wait > maxRetryWait {
+		return maxRetryWait
+	}
+	return wait
+}
+
+// Whether a response is worth asking again for.
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
+}
+
+func sleepContext(ctx context.Context, wait time.Duration) error {
+	select {
+	case <-time.After(wait):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,214 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// Cloud APIs, unlike metadata services, are reached across the network,
+// through any proxy, and are given longer to answer.
+const apiTimeout = 10 * time.Second
+
+func callApi(ctx context.Context, method string, url string, headers map[string]string, body string) (*string, error) {
+	client := settingsOf(ctx).clientFor(apiTimeout, newTransport(&net.Dialer{Timeout: apiTimeout}, settingsOf(ctx).caBundle))
+	val, _, err := fetchWithClient(ctx, client, method, url, headers, body)
+	return val, err
+}
+
+// Print the value of the secret -name, fetched with the credentials the
+// instance was given, as it is so that binary secrets survive.
+func runSecret(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	if globalOpts.secretName == "" {
+		fmt.Fprintf(os.Stderr, "The secret command needs a -name\n")
+		return usageExitCode
+	}
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	var value []byte
+	var err error
+	switch c := cd.(type) {
+	case *AWSCloud:
+		if globalOpts.secretsManager {
+			value, err = c.secretValue(ctx, globalOpts.secretName)
+		} else {
+			value, err = c.parameterValue(ctx, globalOpts.secretName)
+		}
+	case *GCECloud:
+		value, err = c.secretValue(ctx, globalOpts.project, globalOpts.secretName, globalOpts.secretVersion)
+	case *AzureCloud:
+		if globalOpts.vault == "" {
+			fmt.Fprintf(os.Stderr, "Secrets on Azure need the -vault to read them from\n")
+			return usageExitCode
+		}
+		value, err = c.secretValue(ctx, globalOpts.vault, globalOpts.secretName, globalOpts.secretVersion)
+	default:
+		fmt.Fprintf(os.Stderr, "Secrets are not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the secret %s: %s\n", globalOpts.secretName, err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	os.Stdout.Write(value)
+	return foundExitCode
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+// Call an AWS JSON API of the instance's region with the role's
+// credentials.
+func (c *AWSCloud) callJSONApi(ctx context.Context, service string, target string, request interface{}) (*string, error) {
+	region, domain := c.attributes["aws.region"], c.attributes["aws.domain"]
+	if region == "" {
+		return nil, errors.New("The region of the instance is not known")
+	}
+	creds, err := c.roleCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	url := "https://" + service + "." + region + "." + domain + "/"
+	headers := map[string]string{
+		"Content-Type": "application/x-amz-json-1.1",
+		"X-Amz-Target": target,
+	}
+	signed, err := signAWSRequest(creds, service, region, "POST", url, headers, string(body), time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return callApi(ctx, "POST", url, signed, string(body))
+}
+
+// SecureString parameters are decrypted, which needs kms:Decrypt too.
+func (c *AWSCloud) parameterValue(ctx context.Context, name string) ([]byte, error) {
+	doc, err := c.callJSONApi(ctx, "ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": name, "WithDecryption": true})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Parameter struct {
+			Value string `json:"Value"`
+		} `json:"Parameter"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &resp); err != nil {
+		return nil, err
+	}
+	return []byte(resp.Parameter.Value), nil
+}
+
+// The name can be the secret's name or ARN.  Binary secrets are sent base64
+// encoded.
+func (c *AWSCloud) secretValue(ctx context.Context, name string) ([]byte, error) {
+	doc, err := c.callJSONApi(ctx, "secretsmanager", "secretsmanager.GetSecretValue", map[string]interface{}{"SecretId": name})
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		SecretString *string `json:"SecretString"`
+		SecretBinary string  `json:"SecretBinary"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &resp); err != nil {
+		return nil, err
+	}
+	if resp.SecretString != nil {
+		return []byte(*resp.SecretString), nil
+	}
+	return base64.StdEncoding.DecodeString(resp.SecretBinary)
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// The secret is read with a token of the default service account, which
+// needs the cloud-platform scope and roles/secretmanager.secretAccessor.
+// The project defaults to the instance's own.
+func (c *GCECloud) secretValue(ctx context.Context, project string, name string, version string) ([]byte, error) {
+	if project == "" {
+		p, err := c.getKey(ctx, "project/project-id")
+		if err != nil {
+			return nil, err
+		}
+		project = strings.TrimSpace(*p)
+	}
+	if version == "" {
+		version = "latest"
+	}
+	token, err := c.accessToken(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"Authorization": token.TokenType + " " + token.AccessToken}
+	doc, err := callApi(ctx, "GET", c.fingerprint.Urls["secretmanager"]+"projects/"+url.PathEscape(project)+"/secrets/"+url.PathEscape(name)+"/versions/"+url.PathEscape(version)+":access", headers, "")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Payload struct {
+			Data string `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &resp); err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(resp.Payload.Data)
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+const keyVaultApiVersion = "7.4"
+
+// The vault's domain differs between Azure environments, and the token has
+// to be for the Key Vault resource of the same one, e.g.
+// https://myvault.vault.usgovcloudapi.net takes a token for
+// https://vault.usgovcloudapi.net.  The managed identity needs the Get
+// secret permission or the Key Vault Secrets User role.
+func (c *AzureCloud) secretValue(ctx context.Context, vault string, name string, version string) ([]byte, error) {
+	if !strings.Contains(vault, "://") {
+		vault = "https://" + vault
+	}
+	u, err := url.Parse(vault)
+	if err != nil {
+		return nil, err
+	}
+	parts := strings.SplitN(u.Hostname(), ".", 2)
+	if len(parts) != 2 {
+		return nil, errors.New("Invalid Key Vault URL " + vault)
+	}
+	token, err := c.accessToken(ctx, u.Scheme+"://"+parts[1], globalOpts.clientId)
+	if err != nil {
+		return nil, err
+	}
+	secretUrl := strings.TrimSuffix(vault, "/") + "/secrets/" + url.PathEscape(name)
+	if version != "" && version != "latest" {
+		secretUrl += "/" + url.PathEscape(version)
+	}
+	headers := map[string]string{"Authorization": token.TokenType + " " + token.AccessToken}
+	doc, err := callApi(ctx, "GET", secretUrl+"?api-version="+keyVaultApiVersion, headers, "")
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &resp); err != nil {
+		return nil, err
+	}
+	return []byte(resp.Value), nil
+}
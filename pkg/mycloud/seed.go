@@ -0,0 +1,115 @@
+package mycloud
+
+import (
+	"bufio"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// A cloud-init seed drive, NoCloud (cidata) or ConfigDrive (config-2), that
+// is mounted somewhere.  The drives are found through their labels, which
+// udev links under /dev/disk/by-label.  Seed directories that are already
+// on disk, such as /var/lib/cloud/seed/nocloud, can be given instead.
+type seedDrive struct {
+	dir         string
+	configDrive bool
+}
+
+func seedIn(dir string) *seedDrive {
+	s := &seedDrive{dir: dir}
+	if _, err := os.Stat(filepath.Join(dir, "meta-data")); err == nil {
+		return s
+	}
+	if _, err := os.Stat(filepath.Join(dir, "openstack/latest/meta_data.json")); err == nil {
+		s.configDrive = true
+		return s
+	}
+	return nil
+}
+
+func findSeedDrive(paths []string) *seedDrive {
+	devices := map[string]bool{}
+	for _, path := range paths {
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			if s := seedIn(path); s != nil {
+				return s
+			}
+			continue
+		}
+		if dev, err := filepath.EvalSymlinks(path); err == nil {
+			devices[dev] = true
+		}
+	}
+	if len(devices) == 0 {
+		return nil
+	}
+	f, err := os.Open("/proc/mounts")
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !devices[fields[0]] {
+			continue
+		}
+		if s := seedIn(fields[1]); s != nil {
+			return s
+		}
+	}
+	return nil
+}
+
+// The id cloud-init uses to tell one instance from the next.
+func (s *seedDrive) instanceId() (*string, error) {
+	if s.configDrive {
+		return s.metadataKey("uuid")
+	}
+	return s.metadataKey("instance-id")
+}
+
+// ConfigDrive metadata is JSON, so keys are paths into it.  NoCloud
+// metadata is YAML and only its top level scalars can be looked up.
+func (s *seedDrive) metadataKey(key string) (*string, error) {
+	if s.configDrive {
+		doc, err := ioutil.ReadFile(filepath.Join(s.dir, "openstack/latest/meta_data.json"))
+		if err != nil {
+			return nil, err
+		}
+		return lookupKey(string(doc), key)
+	}
+	doc, err := ioutil.ReadFile(filepath.Join(s.dir, "meta-data"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(doc), "\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			continue
+		}
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) == 2 && strings.TrimSpace(parts[0]) == key {
+			v := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+			return &v, nil
+		}
+	}
+	return nil, errors.New("No such key " + key)
+}
+
+// Read a file from a config drive, mounting it first when it is not
+// mounted already.
+func readConfigDrive(paths []string, name string) ([]byte, error) {
+	if s := findSeedDrive(paths); s != nil && s.configDrive {
+		return ioutil.ReadFile(filepath.Join(s.dir, name))
+	}
+	for _, path := range paths {
+		if dev, err := filepath.EvalSymlinks(path); err == nil {
+			return readFromDevice(dev, name)
+		}
+	}
+	return nil, errors.New("No config drive was found")
+}
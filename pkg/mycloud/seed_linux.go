@@ -0,0 +1,31 @@
+//go:build linux
+
+package mycloud
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Mount the drive read-only just long enough to read one file from it.
+// This needs root, which is also what reading the device would need.
+func readFromDevice(dev string, name string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "mycloud-seed")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(dir)
+	for _, fstype := range []string{"iso9660", "vfat"} {
+		err = syscall.Mount(dev, dir, fstype, syscall.MS_RDONLY, "")
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.Unmount(dir, 0)
+	return ioutil.ReadFile(filepath.Join(dir, name))
+}
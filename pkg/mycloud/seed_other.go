@@ -0,0 +1,9 @@
+//go:build !linux
+
+package mycloud
+
+import "errors"
+
+func readFromDevice(dev string, name string) ([]byte, error) {
+	return nil, errors.New("Mounting " + dev + " is not supported on this platform")
+}
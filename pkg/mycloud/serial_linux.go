@@ -0,0 +1,55 @@
+//go:build linux
+
+package mycloud
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Open a serial port in raw mode so nothing we write is echoed back to the
+// other end and lines are not rewritten on the way in.
+func openSerial(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+	var t syscall.Termios
+	if err := termiosIoctl(f, syscall.TCGETS, &t); err != nil {
+		f.Close()
+		return nil, err
+	}
+	t.Iflag &^= syscall.IGNBRK | syscall.BRKINT | syscall.PARMRK | syscall.ISTRIP |
+		syscall.INLCR | syscall.IGNCR | syscall.ICRNL | syscall.IXON
+	t.Oflag &^= syscall.OPOST
+	t.Lflag &^= syscall.ECHO | syscall.ECHONL | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cflag &^= syscall.CSIZE | syscall.PARENB
+	t.Cflag |= syscall.CS8
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := termiosIoctl(f, syscall.TCSETS, &t); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Fd() would put the file in blocking mode and lose the read deadlines.
+func termiosIoctl(f *os.File, request uintptr, t *syscall.Termios) error {
+	rc, err := f.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	err = rc.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, uintptr(unsafe.Pointer(t)))
+	})
+	if err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+
+package mycloud
+
+import (
+	"errors"
+	"os"
+)
+
+// Only linux guests are handed the metadata serial port here; elsewhere
+// the mdata-get binary is used.
+func openSerial(path string) (*os.File, error) {
+	return nil, errors.New("Serial ports are not supported on this platform")
+}
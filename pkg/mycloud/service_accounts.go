@@ -0,0 +1,67 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type ServiceAccount struct {
+	Email   string   `json:"email"`
+	Aliases []string `json:"aliases"`
+	Scopes  []string `json:"scopes"`
+}
+
+// The metadata server lists every account under its email and again under
+// each alias (usually "default"), so fold them into one entry per email.
+func (c *GCECloud) serviceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	doc, err := c.getKey(ctx, "service-accounts/?recursive=true&alt=json")
+	if err != nil {
+		return nil, err
+	}
+	var tree map[string]ServiceAccount
+	if err := json.Unmarshal([]byte(*doc), &tree); err != nil {
+		return nil, err
+	}
+	byEmail := map[string]*ServiceAccount{}
+	for _, sa := range tree {
+		if _, ok := byEmail[sa.Email]; ok {
+			continue
+		}
+		account := sa
+		sort.Strings(account.Aliases)
+		sort.Strings(account.Scopes)
+		byEmail[sa.Email] = &account
+	}
+	accounts := make([]ServiceAccount, 0, len(byEmail))
+	for _, sa := range byEmail {
+		accounts = append(accounts, *sa)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Email < accounts[j].Email })
+	return accounts, nil
+}
+
+func runServiceAccounts(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	gce, ok := cd.(*GCECloud)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Service accounts are only supported on GCE, not %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	accounts, err := gce.serviceAccounts(ctx)
+	if err != nil {
+		logOutput("Failed to get the service accounts.  Error: %s\n", err)
+		status.Error = err.Error()
+		fmt.Printf("UNKNOWN\n")
+		return keyErrorExitCode(err)
+	}
+	out, _ := json.MarshalIndent(accounts, "", "  ")
+	fmt.Printf("%s\n", out)
+	return foundExitCode
+}
@@ -0,0 +1,77 @@
+package mycloud
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+/////////////////////////////////////////////////////////
+// AWS Signature Version 4
+/////////////////////////////////////////////////////////
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func hexSHA256(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// Sign a request to an AWS API with the credentials, and return its headers
+// with the date, session token and Authorization added.  Only requests
+// without a query string are signed, which is all the JSON APIs need.
+func signAWSRequest(creds *AWSCredentials, service string, region string, method string, rawUrl string, headers map[string]string, body string, now time.Time) (map[string]string, error) {
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	amzDate := now.UTC().Format("20060102T150405Z")
+	signed := map[string]string{}
+	for k, v := range headers {
+		signed[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+	signed["host"] = u.Host
+	signed["x-amz-date"] = amzDate
+	if creds.SessionToken != "" {
+		signed["x-amz-security-token"] = creds.SessionToken
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + signed[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{method, path, "", canonicalHeaders, signedHeaders, hexSHA256(body)}, "\n")
+
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hexSHA256(canonicalRequest)}, "\n")
+	key := hmacSHA256([]byte("AWS4"+creds.SecretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	out := map[string]string{}
+	for name, value := range signed {
+		if name != "host" {
+			out[name] = value
+		}
+	}
+	out["authorization"] = "AWS4-HMAC-SHA256 Credential=" + creds.AccessKeyId + "/" + scope + ", SignedHeaders=" + signedHeaders + ", Signature=" + signature
+	return out, nil
+}
@@ -0,0 +1,61 @@
+package mycloud
+
+import (
+	"testing"
+	"time"
+)
+
+// Requests from AWS's Signature Version 4 test suite, with the
+// Authorization headers it publishes for them.
+func TestSignAWSRequest(t *testing.T) {
+	const token = "AQoDYXdzEPT//////////wEXAMPLEtc764bNrC9SAPBSM22wDOk4x4HIZ8j4FZTwdQWLWsKWHGBuFqwAeMicRXmxfpSPfIeoIYRqTflfKD8YUuwthAx7mSEI/qkPpKPi/kMcGdQrmGdeehM4IC1NtBmUpp2wUE8phUZampKsburEDy0KPkyQDYwT7WZ0wq5VSXDvp75YU9HFvlRd8Tx6q6fE8YQcHNVXAkiY9q6d+xo0rKwT38xVqr7ZD0u0iPPkUL64lIZbqBAz+scqKmlzm8FDrypNC9Yjc8fPOLn9FX9KSYvKTr4rvx3iSIlTJabIQwj2ICCR/oLxBA=="
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		method        string
+		headers       map[string]string
+		body          string
+		token         string
+		authorization string
+	}{
+		{
+			name:          "get-vanilla",
+			method:        "GET",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "post-vanilla",
+			method:        "POST",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "post-x-www-form-urlencoded",
+			method:        "POST",
+			headers:       map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:          "Param1=value1",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=ff11897932ad3f4e8b18135d722051e5ac45fc38421b1da7b9d196a0fe09473a",
+		},
+		{
+			name:          "post-sts-header-before",
+			method:        "POST",
+			token:         token,
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date;x-amz-security-token, Signature=85d96828115b5dc0cfc3bd16ad9e210dd772bbebba041836c64533a82be05ead",
+		},
+	}
+	for _, test := range tests {
+		creds := &AWSCredentials{AccessKeyId: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", SessionToken: test.token}
+		headers, err := signAWSRequest(creds, "service", "us-east-1", test.method, "https://example.amazonaws.com/", test.headers, test.body, now)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if got := headers["authorization"]; got != test.authorization {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.authorization)
+		}
+		if got := headers["x-amz-date"]; got != "20150830T123600Z" {
+			t.Errorf("%s: x-amz-date is %s", test.name, got)
+		}
+		if _, ok := headers["host"]; ok {
+			t.Errorf("%s: the host header is returned", test.name)
+		}
+	}
+}
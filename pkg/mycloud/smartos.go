@@ -0,0 +1,144 @@
+package mycloud
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The SmartOS metadata protocol, version 2, as spoken by mdata-get.  Zones
+// reach the metadata agent through a unix socket and hardware virtualized
+// guests through the second serial port.  Each request and response is a
+// line of the form
+//
+//	V2 <length> <crc32> <request id> <code> [<base64 payload>]
+//
+// where the length and the CRC32 cover everything after the CRC.
+const mdataTimeout = 2 * time.Second
+
+type mdataChannel interface {
+	io.ReadWriteCloser
+	SetDeadline(time.Time) error
+}
+
+type mdataClient struct {
+	lock   sync.Mutex
+	conn   mdataChannel
+	reader *bufio.Reader
+}
+
+func dialMetadata(path string) (*mdataClient, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	var conn mdataChannel
+	switch {
+	case info.Mode()&os.ModeSocket != 0:
+		conn, err = net.DialTimeout("unix", path, mdataTimeout)
+	case info.Mode()&os.ModeCharDevice != 0:
+		conn, err = openSerial(path)
+	default:
+		return nil, errors.New(path + " is not a socket or a serial port")
+	}
+	if err != nil {
+		return nil, err
+	}
+	c := &mdataClient{conn: conn, reader: bufio.NewReader(conn)}
+	if err := c.negotiate(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *mdataClient) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	return strings.TrimRight(line, "\r\n"), err
+}
+
+// A serial port may hold the tail of an earlier conversation, so a bare
+// newline is sent first and anything that is not the answer is skipped.
+func (c *mdataClient) negotiate() error {
+	c.conn.SetDeadline(time.Now().Add(mdataTimeout))
+	if _, err := io.WriteString(c.conn, "\nNEGOTIATE V2\n"); err != nil {
+		return err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return err
+		}
+		if line == "V2_OK" {
+			return nil
+		}
+		if line == "invalid command" || line == "" {
+			continue
+		}
+		return errors.New("The metadata agent does not speak V2: " + line)
+	}
+}
+
+func (c *mdataClient) request(code string, payload string) (string, string, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	id := fmt.Sprintf("%08x", rand.Uint32())
+	body := id + " " + code
+	if payload != "" {
+		body += " " + base64.StdEncoding.EncodeToString([]byte(payload))
+	}
+	c.conn.SetDeadline(time.Now().Add(mdataTimeout))
+	frame := fmt.Sprintf("V2 %d %08x %s\n", len(body), crc32.ChecksumIEEE([]byte(body)), body)
+	if _, err := io.WriteString(c.conn, frame); err != nil {
+		return "", "", err
+	}
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return "", "", err
+		}
+		fields := strings.SplitN(line, " ", 4)
+		if len(fields) != 4 || fields[0] != "V2" {
+			continue
+		}
+		length, _ := strconv.Atoi(fields[1])
+		crc, _ := strconv.ParseUint(fields[2], 16, 32)
+		if length != len(fields[3]) || uint32(crc) != crc32.ChecksumIEEE([]byte(fields[3])) {
+			return "", "", errors.New("Corrupt response from the metadata agent")
+		}
+		reply := strings.SplitN(fields[3], " ", 3)
+		if len(reply) < 2 || reply[0] != id {
+			continue
+		}
+		if len(reply) == 2 {
+			return reply[1], "", nil
+		}
+		data, err := base64.StdEncoding.DecodeString(reply[2])
+		return reply[1], string(data), err
+	}
+}
+
+func (c *mdataClient) get(key string) (*string, error) {
+	code, value, err := c.request("GET", key)
+	if err != nil {
+		return nil, err
+	}
+	switch code {
+	case "SUCCESS":
+		return &value, nil
+	case "NOTFOUND":
+		return nil, errors.New("No such key " + key)
+	}
+	return nil, errors.New("The metadata agent answered " + code)
+}
@@ -0,0 +1,218 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Clouds that provision SSH public keys for the instance.
+type SSHKeySource interface {
+	sshKeys(context.Context) ([]string, error)
+}
+
+// Keys are printed one per line, ready for authorized_keys.  The same key
+// given twice, say to the project and the instance, is printed once.
+func authorizedKeys(keys []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, key := range keys {
+		key = strings.TrimSpace(key)
+		if key == "" || strings.HasPrefix(key, "#") || seen[key] {
+			continue
+		}
+		seen[key] = true
+		out = append(out, key)
+	}
+	return out
+}
+
+func runSSHKeys(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	source, ok := sshKeySourceOf(cd)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "SSH keys are not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	keys, err := source.sshKeys(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the SSH keys: %s\n", err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	for _, key := range authorizedKeys(keys) {
+		fmt.Printf("%s\n", key)
+	}
+	return foundExitCode
+}
+
+// Values that hold several keys, one per line.
+func keyLines(val *string, err error) ([]string, error) {
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(*val, "\n"), nil
+}
+
+/////////////////////////////////////////////////////////
+// EC2 style trees
+/////////////////////////////////////////////////////////
+// public-keys/ lists the keys as <index>=<name>, and each key is under
+// public-keys/<index>/openssh-key.
+func treePublicKeys(ctx context.Context, cd CloudDetector) ([]string, error) {
+	listing, err := cd.getKey(ctx, "public-keys/")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, line := range strings.Split(*listing, "\n") {
+		index := strings.SplitN(strings.TrimSpace(line), "=", 2)[0]
+		if index == "" {
+			continue
+		}
+		key, err := cd.getKey(ctx, "public-keys/"+index+"/openssh-key")
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, strings.Split(*key, "\n")...)
+	}
+	return keys, nil
+}
+
+func (c *AWSCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return treePublicKeys(ctx, c)
+}
+
+func (c *EC2CompatibleCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return treePublicKeys(ctx, c)
+}
+
+func (c *EC2CloneCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return treePublicKeys(ctx, c)
+}
+
+func (c *AlibabaCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return treePublicKeys(ctx, c)
+}
+
+// CloudStack serves the key itself rather than a listing.
+func (c *CloudStackCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return keyLines(c.getKey(ctx, "public-keys"))
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// Keys are given as <user>:<key> in the ssh-keys attribute of the instance
+// and of the project, unless the instance blocks the project's keys.
+func (c *GCECloud) sshKeys(ctx context.Context) ([]string, error) {
+	attributes := []string{"instance/attributes/ssh-keys"}
+	if blocked, err := c.getKey(ctx, "instance/attributes/block-project-ssh-keys"); err != nil || strings.TrimSpace(*blocked) != "true" {
+		attributes = append(attributes, "project/attributes/ssh-keys")
+	}
+	var keys []string
+	var lastErr error
+	found := false
+	for _, attribute := range attributes {
+		lines, err := keyLines(c.getKey(ctx, attribute))
+		if err != nil {
+			if classifyError(err) != ErrorCategoryHTTPStatus {
+				return nil, err
+			}
+			lastErr = err
+			continue
+		}
+		found = true
+		for _, line := range lines {
+			if i := strings.Index(line, ":"); i >= 0 && !strings.Contains(line[:i], " ") {
+				line = line[i+1:]
+			}
+			keys = append(keys, line)
+		}
+	}
+	if !found {
+		return nil, lastErr
+	}
+	return keys, nil
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+func (c *AzureCloud) sshKeys(ctx context.Context) ([]string, error) {
+	doc, err := c.getKey(ctx, "compute/publicKeys")
+	if err != nil {
+		return nil, err
+	}
+	var publicKeys []struct {
+		KeyData string `json:"keyData"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &publicKeys); err != nil {
+		return nil, err
+	}
+	var keys []string
+	for _, key := range publicKeys {
+		keys = append(keys, key.KeyData)
+	}
+	return keys, nil
+}
+
+/////////////////////////////////////////////////////////
+// JSON documents
+/////////////////////////////////////////////////////////
+// public_keys maps the key names to the keys, so they are printed in the
+// order of their names.
+func (c *OpenStackCloud) sshKeys(ctx context.Context) ([]string, error) {
+	doc, err := c.getKey(ctx, "public_keys")
+	if err != nil {
+		return nil, err
+	}
+	var byName map[string]string
+	if err := json.Unmarshal([]byte(*doc), &byName); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(byName))
+	for name := range byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var keys []string
+	for _, name := range names {
+		keys = append(keys, byName[name])
+	}
+	return keys, nil
+}
+
+func (c *DigitalOceanCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return keyLines(c.getKey(ctx, "public-keys"))
+}
+
+func (c *EquinixCloud) sshKeys(ctx context.Context) ([]string, error) {
+	doc, err := c.getKey(ctx, "ssh_keys")
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	if err := json.Unmarshal([]byte(*doc), &keys); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (c *OCICloud) sshKeys(ctx context.Context) ([]string, error) {
+	return keyLines(c.getKey(ctx, "metadata/ssh_authorized_keys"))
+}
+
+/////////////////////////////////////////////////////////
+// Joyent
+/////////////////////////////////////////////////////////
+func (c *JoyentCloud) sshKeys(ctx context.Context) ([]string, error) {
+	return keyLines(c.getKey(ctx, "root_authorized_keys"))
+}
@@ -0,0 +1,135 @@
+package mycloud
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"time"
+)
+
+// Set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+type ProbeStatus struct {
+	Cloud         string `json:"cloud"`
+	Detected      bool   `json:"detected"`
+	Skipped       bool   `json:"skipped,omitempty"`
+	Stopped       bool   `json:"stopped,omitempty"`
+	Confidence    int    `json:"confidence,omitempty"`
+	DurationMs    int64  `json:"duration_ms"`
+	Error         string `json:"error,omitempty"`
+	ErrorCategory string `json:"error_category,omitempty"`
+}
+
+// The summary written by --status-file so that monitoring agents can see the
+// outcome of the last run.  Key values are left out on purpose since they
+// may be secrets and the file is world readable.
+type RunStatus struct {
+	Version             string            `json:"version"`
+	FingerprintsVersion int               `json:"fingerprints_version"`
+	Started             time.Time         `json:"started"`
+	DurationMs          int64             `json:"duration_ms"`
+	ExitCode            int               `json:"exit_code"`
+	Cloud               string            `json:"cloud"`
+	Attributes          map[string]string `json:"attributes,omitempty"`
+	Key                 string            `json:"key,omitempty"`
+	Error               string            `json:"error,omitempty"`
+	Probes              []ProbeStatus     `json:"probes"`
+}
+
+func newRunStatus() *RunStatus {
+	return &RunStatus{
+		Version:             version,
+		FingerprintsVersion: loadedFingerprints().Version,
+		Started:             time.Now(),
+		Cloud:               "UNKNOWN"}
+}
+
+// A probe stopped because another cloud was confirmed did not fail.
+func (s *RunStatus) addProbe(cd CloudDetector, elapsed time.Duration, skipped bool, stopped bool) {
+	probe := ProbeStatus{
+		Cloud:      cd.cloudDescription(),
+		Detected:   cd.isEffectiveCloud(),
+		Skipped:    skipped,
+		Stopped:    stopped,
+		Confidence: cd.cloudConfidence(),
+		DurationMs: elapsed.Nanoseconds() / int64(time.Millisecond)}
+	if err := cd.cloudProbeError(); err != nil && !stopped {
+		probe.Error = err.Error()
+		probe.ErrorCategory = classifyError(err)
+	}
+	s.Probes = append(s.Probes, probe)
+}
+
+func (s *RunStatus) finish(rc int) {
+	s.ExitCode = rc
+	s.DurationMs = time.Since(s.Started).Nanoseconds() / int64(time.Millisecond)
+}
+
+func (s *RunStatus) write(path string) error {
+	out, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(out, '\n'), 0644)
+}
+
+// Write to a temporary file in the same directory and rename it over the
+// old one so that readers never see a partial document.
+func writeFileAtomic(path string, data []byte, mode os.FileMode) error {
+	tmp, err := createTemp(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	return replaceWithTemp(tmp, path)
+}
+
+// A temporary file next to path, to be renamed over it.
+func createTemp(path string) (*os.File, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return ioutil.TempFile(dir, "."+filepath.Base(path)+".")
+}
+
+// Close the written temporary file and rename it over path.  The file is
+// synced before the rename and the directory after it, so that after a
+// crash path holds the old document or the new one, never an empty file.
+func replaceWithTemp(tmp *os.File, path string) error {
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// Windows cannot sync a directory, and does not need to for a rename.
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,342 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+// The handful of fields nearly every caller wants, gathered in one pass.
+type Summary struct {
+	Provider     string            `json:"provider"`
+	AccountId    string            `json:"account_id,omitempty"`
+	InstanceId   string            `json:"instance_id,omitempty"`
+	InstanceType string            `json:"instance_type,omitempty"`
+	Region       string            `json:"region,omitempty"`
+	Zone         string            `json:"zone,omitempty"`
+	PrivateIp    string            `json:"private_ip,omitempty"`
+	PublicIp     string            `json:"public_ip,omitempty"`
+	Hostname     string            `json:"hostname,omitempty"`
+	Lifecycle    string            `json:"lifecycle,omitempty"`
+	Tags         map[string]string `json:"tags,omitempty"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+}
+
+// A summary field and the metadata key it comes from on a given cloud.
+type summaryField struct {
+	name      string
+	key       string
+	transform func(string) string
+}
+
+// Clouds that can fill in a summary beyond the provider name.
+type Summarizer interface {
+	summaryFields() []summaryField
+	tags(context.Context) (map[string]string, error)
+}
+
+// Clouds whose owning account is not a key of its own but part of a
+// document, such as AWS's identity document.
+type AccountIdentifier interface {
+	accountId(context.Context) (string, error)
+}
+
+func fetchFields(ctx context.Context, cd CloudDetector, fields []summaryField) map[string]string {
+	values := map[string]string{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	wg.Add(len(fields))
+	for _, f := range fields {
+		go func(f summaryField) {
+			defer wg.Done()
+			val, err := cd.getKey(ctx, f.key)
+			if err != nil {
+				logOutput("Could not get %s from the key %s: %s\n", f.name, f.key, err)
+				return
+			}
+			v := strings.TrimSpace(*val)
+			if f.transform != nil {
+				v = f.transform(v)
+			}
+			lock.Lock()
+			values[f.name] = v
+			lock.Unlock()
+		}(f)
+	}
+	wg.Wait()
+	return values
+}
+
+func summarize(ctx context.Context, cd CloudDetector) Summary {
+	s := Summary{Provider: cd.cloudDescription(), Attributes: cd.cloudAttributes()}
+	summarizer, ok := summarizerOf(cd)
+	if !ok {
+		return s
+	}
+	done := make(chan bool)
+	go func() {
+		tags, err := summarizer.tags(ctx)
+		if err != nil {
+			logOutput("Could not get the tags: %s\n", err)
+		}
+		s.Tags = tags
+		if identifier, ok := accountIdentifierOf(cd); ok {
+			id, err := identifier.accountId(ctx)
+			if err != nil {
+				logOutput("Could not get the account id: %s\n", err)
+			}
+			s.AccountId = id
+		}
+		done <- true
+	}()
+	values := fetchFields(ctx, cd, summarizer.summaryFields())
+	<-done
+	if s.AccountId == "" {
+		s.AccountId = values["account_id"]
+	}
+	s.InstanceId = values["instance_id"]
+	s.InstanceType = values["instance_type"]
+	s.Region = values["region"]
+	s.Zone = values["zone"]
+	s.PrivateIp = values["private_ip"]
+	s.PublicIp = values["public_ip"]
+	s.Hostname = values["hostname"]
+	s.Lifecycle = values["lifecycle"]
+	return s
+}
+
+func runSummary(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	out, err := json.MarshalIndent(summarize(ctx, cd), "", "  ")
+	if err != nil {
+		status.Error = err.Error()
+		return errorExitCode
+	}
+	fmt.Printf("%s\n", out)
+	return foundExitCode
+}
+
+// Tags that are listed one name per line under a directory key.
+func listedTags(ctx context.Context, cd CloudDetector, dir string, withValues bool) (map[string]string, error) {
+	listing, err := cd.getKey(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	tags := map[string]string{}
+	for _, name := range strings.Split(*listing, "\n") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		tags[name] = ""
+		if withValues {
+			val, err := cd.getKey(ctx, dir+name)
+			if err != nil {
+				return nil, err
+			}
+			tags[name] = *val
+		}
+	}
+	return tags, nil
+}
+
+// Tags kept as a JSON object of strings under one key.
+func jsonTags(ctx context.Context, cd CloudDetector, key string) (map[string]string, error) {
+	val, err := cd.getKey(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	var tags map[string]string
+	if err := json.Unmarshal([]byte(*val), &tags); err != nil {
+		return nil, fmt.Errorf("The tags under %s are not a JSON object: %s", key, err)
+	}
+	return tags, nil
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+func (c *AWSCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "instance-id", nil},
+		{"instance_type", "instance-type", nil},
+		{"region", "placement/region", nil},
+		{"zone", "placement/availability-zone", nil},
+		{"private_ip", "local-ipv4", nil},
+		{"public_ip", "public-ipv4", nil},
+		{"lifecycle", "instance-life-cycle", nil},
+		{"hostname", "local-hostname", nil},
+	}
+}
+
+func (c *AWSCloud) accountId(ctx context.Context) (string, error) {
+	doc, _, err := getUrl(ctx, c.fingerprint.Urls["identity"], c.headers)
+	if err != nil {
+		return "", err
+	}
+	id, err := provider.LookupJSON(*doc, []string{"accountId"})
+	if err != nil {
+		return "", err
+	}
+	return *id, nil
+}
+
+// Only available when tags are allowed in the instance metadata options.
+func (c *AWSCloud) tags(ctx context.Context) (map[string]string, error) {
+	return listedTags(ctx, c, "tags/instance/", true)
+}
+
+/////////////////////////////////////////////////////////
+// EC2-compatible clouds
+/////////////////////////////////////////////////////////
+func (c *EC2CompatibleCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "instance-id", nil},
+		{"instance_type", "instance-type", nil},
+		{"zone", "placement/availability-zone", nil},
+		{"private_ip", "local-ipv4", nil},
+		{"public_ip", "public-ipv4", nil},
+		{"hostname", "local-hostname", nil},
+	}
+}
+
+func (c *EC2CompatibleCloud) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+func (c *EC2CloneCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "instance-id", nil},
+		{"instance_type", "instance-type", nil},
+		{"zone", "placement/availability-zone", nil},
+		{"private_ip", "local-ipv4", nil},
+		{"public_ip", "public-ipv4", nil},
+		{"hostname", "local-hostname", nil},
+	}
+}
+
+func (c *EC2CloneCloud) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+func gceLifecycle(preemptible string) string {
+	if preemptible == "TRUE" {
+		return "preemptible"
+	}
+	return "standard"
+}
+
+func (c *GCECloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "id", nil},
+		{"instance_type", "machine-type", path.Base},
+		{"region", "zone", provider.RegionOfZone},
+		{"zone", "zone", path.Base},
+		{"private_ip", "network-interfaces/0/ip", nil},
+		{"public_ip", "network-interfaces/0/access-configs/0/external-ip", nil},
+		{"lifecycle", "scheduling/preemptible", gceLifecycle},
+		{"hostname", "hostname", nil},
+		{"account_id", "project/project-id", nil},
+	}
+}
+
+// GCE labels are not in the metadata server, only the network tags are.
+func (c *GCECloud) tags(ctx context.Context) (map[string]string, error) {
+	return provider.JSONListTags(ctx, c.getKey, "tags")
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+func (c *AzureCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "compute/vmId", nil},
+		{"instance_type", "compute/vmSize", nil},
+		{"region", "compute/location", nil},
+		{"zone", "compute/zone", nil},
+		{"private_ip", "network/interface/0/ipv4/ipAddress/0/privateIpAddress", nil},
+		{"public_ip", "network/interface/0/ipv4/ipAddress/0/publicIpAddress", nil},
+		{"lifecycle", "compute/priority", strings.ToLower},
+		{"hostname", "compute/osProfile/computerName", nil},
+		{"account_id", "compute/subscriptionId", nil},
+	}
+}
+
+func (c *AzureCloud) tags(ctx context.Context) (map[string]string, error) {
+	val, err := c.getKey(ctx, "compute/tagsList")
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Name  string `json:"name"`
+		Value string `json:"value"`
+	}
+	if err := json.Unmarshal([]byte(*val), &list); err != nil {
+		return nil, fmt.Errorf("The tags under compute/tagsList are not a JSON list: %s", err)
+	}
+	tags := map[string]string{}
+	for _, t := range list {
+		tags[t.Name] = t.Value
+	}
+	return tags, nil
+}
+
+/////////////////////////////////////////////////////////
+// OpenStack
+/////////////////////////////////////////////////////////
+func (c *OpenStackCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "uuid", nil},
+		{"zone", "availability_zone", nil},
+		{"hostname", "hostname", nil},
+	}
+}
+
+func (c *OpenStackCloud) tags(ctx context.Context) (map[string]string, error) {
+	return jsonTags(ctx, c, "meta")
+}
+
+/////////////////////////////////////////////////////////
+// Digital Ocean
+/////////////////////////////////////////////////////////
+func (c *DigitalOceanCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "id", nil},
+		{"region", "region", nil},
+		{"private_ip", "interfaces/private/0/ipv4/address", nil},
+		{"public_ip", "interfaces/public/0/ipv4/address", nil},
+		{"hostname", "hostname", nil},
+	}
+}
+
+func (c *DigitalOceanCloud) tags(ctx context.Context) (map[string]string, error) {
+	return listedTags(ctx, c, "tags/", false)
+}
+
+/////////////////////////////////////////////////////////
+// Joyent
+/////////////////////////////////////////////////////////
+func (c *JoyentCloud) summaryFields() []summaryField {
+	return []summaryField{
+		{"instance_id", "sdc:uuid", nil},
+		{"instance_type", "sdc:package_name", nil},
+		{"region", "sdc:datacenter_name", nil},
+		{"hostname", "sdc:hostname", nil},
+	}
+}
+
+func (c *JoyentCloud) tags(ctx context.Context) (map[string]string, error) {
+	return nil, nil
+}
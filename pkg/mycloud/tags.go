@@ -0,0 +1,57 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// The tags or labels of the instance, whatever the cloud calls them, as
+// the one map the summary carries.  Clouds with plain tags, such as GCE's
+// network tags, give them empty values.
+func runTags(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	summarizer, ok := summarizerOf(cd)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Tags are not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	tags, err := summarizer.tags(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the tags: %s\n", err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	if tags == nil {
+		tags = map[string]string{}
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	switch globalOpts.format {
+	case "json":
+		out, err := json.MarshalIndent(tags, "", "  ")
+		if err != nil {
+			status.Error = err.Error()
+			return errorExitCode
+		}
+		fmt.Printf("%s\n", out)
+	case "env":
+		for _, name := range names {
+			fmt.Printf("MYCLOUD_TAG_%s=%s\n", envName(name), envQuote(tags[name]))
+		}
+	default:
+		for _, name := range names {
+			fmt.Printf("%s=%s\n", name, tags[name])
+		}
+	}
+	return foundExitCode
+}
@@ -0,0 +1,64 @@
+package mycloud
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// What a -template is rendered against: the result along with the summary
+// fields, e.g. {{.Cloud}}:{{.Region}}.  Any other key can be fetched with
+// the key function, e.g. {{key "placement/availability-zone"}}.
+type templateData struct {
+	Result
+	InstanceId   string
+	InstanceType string
+	Region       string
+	Zone         string
+	PrivateIp    string
+	PublicIp     string
+	Hostname     string
+	Lifecycle    string
+	Tags         map[string]string
+}
+
+// The key function is bound when the template is run, this one only lets
+// the template be checked up front.
+func parseTemplate(text string) (*template.Template, error) {
+	return template.New("output").Funcs(template.FuncMap{
+		"key": func(string) (string, error) { return "", nil },
+	}).Parse(text)
+}
+
+func writeTemplate(ctx context.Context, tmpl *template.Template, cd CloudDetector, result Result) error {
+	data := templateData{Result: result}
+	if cd != nil {
+		s := summarize(ctx, cd)
+		data.InstanceId = s.InstanceId
+		data.InstanceType = s.InstanceType
+		data.Region = s.Region
+		data.Zone = s.Zone
+		data.PrivateIp = s.PrivateIp
+		data.PublicIp = s.PublicIp
+		data.Hostname = s.Hostname
+		data.Lifecycle = s.Lifecycle
+		data.Tags = s.Tags
+	}
+	key := func(name string) (string, error) {
+		if cd == nil {
+			return "", errors.New("No cloud was detected")
+		}
+		val, err := cd.getKey(ctx, name)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(*val), nil
+	}
+	if err := tmpl.Funcs(template.FuncMap{"key": key}).Execute(os.Stdout, data); err != nil {
+		return err
+	}
+	_, err := os.Stdout.WriteString("\n")
+	return err
+}
@@ -0,0 +1,314 @@
+package mycloud
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// Clouds that hand the instance user data, wherever they keep it.
+type UserDataSource interface {
+	userData(context.Context) (*string, error)
+}
+
+// Some platform configuration only arrives as vendor data, which the cloud
+// rather than the user provides.
+type VendorDataSource interface {
+	vendorData(context.Context) (*string, error)
+}
+
+// Clouds and tools wrap user data in base64 and gzip, in either order and
+// sometimes twice, so the layers are peeled off until neither applies.
+// Base64 is only taken off when what it decodes to is gzip or text, since
+// short plain values can happen to be valid base64.
+func unwrapUserData(data []byte) []byte {
+	for i := 0; i < 4; i++ {
+		if bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+			out, err := decodeGzip(data)
+			if err != nil {
+				return data
+			}
+			data = out
+			continue
+		}
+		out, err := decodeBase64(data)
+		if err != nil || len(out) == 0 || !(bytes.HasPrefix(out, []byte{0x1f, 0x8b}) || utf8.Valid(out)) {
+			return data
+		}
+		data = out
+	}
+	return data
+}
+
+// cloud-init takes multipart MIME archives of several parts.  A part is
+// picked by its file name or its content type, e.g. text/cloud-config.
+func extractPart(data []byte, name string) ([]byte, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.New("The user data is not a MIME archive")
+	}
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, errors.New("The user data is not a MIME archive")
+	}
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var names []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		names = append(names, part.FileName()+" ("+partType+")")
+		if part.FileName() != name && partType != name {
+			continue
+		}
+		body, err := ioutil.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+		if strings.EqualFold(part.Header.Get("Content-Transfer-Encoding"), "base64") {
+			if body, err = decodeBase64(body); err != nil {
+				return nil, err
+			}
+		}
+		return unwrapUserData(body), nil
+	}
+	return nil, errors.New("No part " + name + " in the user data, it has: " + strings.Join(names, ", "))
+}
+
+func runUserData(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	what := "user data"
+	var fetch func(context.Context) (*string, error)
+	if globalOpts.vendorData {
+		what = "vendor data"
+		if source, ok := vendorDataSourceOf(cd); ok {
+			fetch = source.vendorData
+		}
+	} else if source, ok := userDataSourceOf(cd); ok {
+		fetch = source.userData
+	}
+	if fetch == nil {
+		fmt.Fprintf(os.Stderr, "The %s is not supported on %s\n", what, cd.cloudDescription())
+		return errorExitCode
+	}
+	val, err := fetch(ctx)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to get the %s: %s\n", what, err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	data := unwrapUserData([]byte(*val))
+	if globalOpts.part != "" {
+		if data, err = extractPart(data, globalOpts.part); err != nil {
+			fmt.Fprintf(os.Stderr, "%s\n", err)
+			status.Error = err.Error()
+			return keyMissingExitCode
+		}
+	}
+	os.Stdout.Write(data)
+	return foundExitCode
+}
+
+/////////////////////////////////////////////////////////
+// EC2 style trees
+/////////////////////////////////////////////////////////
+// The user data sits beside the meta-data tree.
+func siblingUserData(ctx context.Context, c *SimpleUrlBasedCloud) (*string, error) {
+	url := strings.TrimSuffix(c.baseUrl, "meta-data/") + "user-data"
+	val, _, err := getUrl(ctx, url, c.headers)
+	return val, err
+}
+
+func (c *AWSCloud) userData(ctx context.Context) (*string, error) {
+	return siblingUserData(ctx, &c.SimpleUrlBasedCloud)
+}
+
+func (c *EC2CompatibleCloud) userData(ctx context.Context) (*string, error) {
+	return siblingUserData(ctx, &c.SimpleUrlBasedCloud)
+}
+
+func (c *EC2CloneCloud) userData(ctx context.Context) (*string, error) {
+	return siblingUserData(ctx, &c.SimpleUrlBasedCloud)
+}
+
+func (c *AlibabaCloud) userData(ctx context.Context) (*string, error) {
+	return siblingUserData(ctx, &c.SimpleUrlBasedCloud)
+}
+
+func (c *CloudStackCloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "user-data")
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// GCE has no user data of its own, cloud-init reads the user-data
+// attribute.
+func (c *GCECloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "attributes/user-data")
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+// IMDS serves the user data, base64 encoded.  The custom data given at
+// creation is only in the agent's ovf-env.xml.
+func (c *AzureCloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "compute/userData")
+}
+
+/////////////////////////////////////////////////////////
+// OpenStack
+/////////////////////////////////////////////////////////
+func (c *OpenStackCloud) userData(ctx context.Context) (*string, error) {
+	if c.attributes["openstack.metadata-source"] == "config-drive" {
+		doc, err := readConfigDrive(c.fingerprint.Files, "openstack/latest/user_data")
+		if err != nil {
+			return nil, err
+		}
+		s := string(doc)
+		return &s, nil
+	}
+	val, _, err := getUrl(ctx, c.baseUrl+c.version+"/user_data", c.headers)
+	return val, err
+}
+
+/////////////////////////////////////////////////////////
+// Other metadata services
+/////////////////////////////////////////////////////////
+func (c *DigitalOceanCloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "user-data")
+}
+
+func (c *OCICloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "metadata/user_data")
+}
+
+func (c *EquinixCloud) userData(ctx context.Context) (*string, error) {
+	val, _, err := getUrl(ctx, strings.TrimSuffix(c.baseUrl, "metadata")+"userdata", c.headers)
+	return val, err
+}
+
+func (c *JoyentCloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "user-data")
+}
+
+// Set with guestinfo.userdata, and guestinfo.userdata.encoding which the
+// unwrapping makes unnecessary.
+func (c *VSphereCloud) userData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "guestinfo.userdata")
+}
+
+/////////////////////////////////////////////////////////
+// Seed drives
+/////////////////////////////////////////////////////////
+func (s *seedDrive) userData(ctx context.Context) (*string, error) {
+	name := "user-data"
+	if s.configDrive {
+		name = "openstack/latest/user_data"
+	}
+	doc, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	v := string(doc)
+	return &v, nil
+}
+
+func (c *NoCloudDetector) userData(ctx context.Context) (*string, error) {
+	if c.seed == nil {
+		return nil, errors.New("No NoCloud seed was found")
+	}
+	return c.seed.userData(ctx)
+}
+
+func (c *ProxmoxCloud) userData(ctx context.Context) (*string, error) {
+	return c.seed.userData(ctx)
+}
+
+/////////////////////////////////////////////////////////
+// Vendor data
+/////////////////////////////////////////////////////////
+// OpenStack has static vendor data (vendor_data.json) and vendor data its
+// dynamic services returned (vendor_data2.json).  Both are printed as one
+// document, under the names of their files.
+func (c *OpenStackCloud) vendorData(ctx context.Context) (*string, error) {
+	docs := map[string]json.RawMessage{}
+	var lastErr error
+	for _, name := range []string{"vendor_data", "vendor_data2"} {
+		var doc *string
+		var err error
+		if c.attributes["openstack.metadata-source"] == "config-drive" {
+			var b []byte
+			if b, err = readConfigDrive(c.fingerprint.Files, "openstack/latest/"+name+".json"); err == nil {
+				s := string(b)
+				doc = &s
+			}
+		} else {
+			doc, _, err = getUrl(ctx, c.baseUrl+c.version+"/"+name+".json", c.headers)
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if json.Valid([]byte(*doc)) {
+			docs[name] = json.RawMessage(*doc)
+		}
+	}
+	if len(docs) == 0 {
+		if lastErr == nil {
+			lastErr = errors.New("No vendor data")
+		}
+		return nil, lastErr
+	}
+	out, err := json.MarshalIndent(docs, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	s := string(out) + "\n"
+	return &s, nil
+}
+
+func (c *DigitalOceanCloud) vendorData(ctx context.Context) (*string, error) {
+	return c.getKey(ctx, "vendor-data")
+}
+
+func (s *seedDrive) vendorData(ctx context.Context) (*string, error) {
+	name := "vendor-data"
+	if s.configDrive {
+		name = "openstack/latest/vendor_data.json"
+	}
+	doc, err := ioutil.ReadFile(filepath.Join(s.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	v := string(doc)
+	return &v, nil
+}
+
+func (c *NoCloudDetector) vendorData(ctx context.Context) (*string, error) {
+	if c.seed == nil {
+		return nil, errors.New("No NoCloud seed was found")
+	}
+	return c.seed.vendorData(ctx)
+}
+
+func (c *ProxmoxCloud) vendorData(ctx context.Context) (*string, error) {
+	return c.seed.vendorData(ctx)
+}
@@ -0,0 +1,228 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// The auth section of a Vault login response.
+type VaultAuth struct {
+	ClientToken   string            `json:"client_token"`
+	Accessor      string            `json:"accessor"`
+	Policies      []string          `json:"policies"`
+	Metadata      map[string]string `json:"metadata"`
+	LeaseDuration int64             `json:"lease_duration"`
+	Renewable     bool              `json:"renewable"`
+}
+
+// Log in to the auth method mounted at mount, by default named after the
+// method.  VAULT_NAMESPACE is honoured like the vault CLI does.
+func vaultLogin(ctx context.Context, addr string, mount string, login map[string]interface{}) (*VaultAuth, error) {
+	body, err := json.Marshal(login)
+	if err != nil {
+		return nil, err
+	}
+	headers := map[string]string{"Content-Type": "application/json"}
+	if namespace := os.Getenv("VAULT_NAMESPACE"); namespace != "" {
+		headers["X-Vault-Namespace"] = namespace
+	}
+	doc, err := callApi(ctx, "POST", strings.TrimSuffix(addr, "/")+"/v1/auth/"+strings.Trim(mount, "/")+"/login", headers, string(body))
+	if err != nil {
+		return nil, err
+	}
+	var resp struct {
+		Auth *VaultAuth `json:"auth"`
+	}
+	if err := json.Unmarshal([]byte(*doc), &resp); err != nil {
+		return nil, err
+	}
+	if resp.Auth == nil || resp.Auth.ClientToken == "" {
+		return nil, errors.New("Vault did not return a token")
+	}
+	return resp.Auth, nil
+}
+
+func writeVaultAuth(auth *VaultAuth) error {
+	switch globalOpts.format {
+	case "json":
+		out, err := json.MarshalIndent(auth, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", out)
+	case "env":
+		fmt.Printf("VAULT_TOKEN=%s\n", envQuote(auth.ClientToken))
+	default:
+		fmt.Printf("%s\n", auth.ClientToken)
+	}
+	return nil
+}
+
+// Log in to Vault as -vault-role with the identity the cloud gave the
+// instance and print the token.
+func runVaultLogin(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	if globalOpts.vaultAddr == "" {
+		fmt.Fprintf(os.Stderr, "The vault-login command needs a -vault-addr or VAULT_ADDR\n")
+		return usageExitCode
+	}
+	if globalOpts.vaultRole == "" {
+		fmt.Fprintf(os.Stderr, "The vault-login command needs a -vault-role\n")
+		return usageExitCode
+	}
+	if globalOpts.vaultAwsMethod != "iam" && globalOpts.vaultAwsMethod != "ec2" {
+		fmt.Fprintf(os.Stderr, "Invalid -vault-aws-method %s, it is iam or ec2\n", globalOpts.vaultAwsMethod)
+		return usageExitCode
+	}
+	cd := detect(ctx, cdList, status)
+	if cd == nil {
+		fmt.Printf("UNKNOWN\n")
+		return detectionFailedExitCode(cdList)
+	}
+	var login map[string]interface{}
+	var method string
+	var err error
+	switch c := cd.(type) {
+	case *AWSCloud:
+		method = "aws"
+		if globalOpts.vaultAwsMethod == "ec2" {
+			login, err = c.vaultEC2Login(ctx, globalOpts.vaultRole, globalOpts.vaultNonce)
+		} else {
+			login, err = c.vaultIAMLogin(ctx, globalOpts.vaultRole, globalOpts.vaultServerId)
+		}
+	case *GCECloud:
+		method = "gcp"
+		login, err = c.vaultLogin(ctx, globalOpts.vaultRole)
+	case *AzureCloud:
+		method = "azure"
+		login, err = c.vaultLogin(ctx, globalOpts.vaultRole)
+	default:
+		fmt.Fprintf(os.Stderr, "Vault login is not supported on %s\n", cd.cloudDescription())
+		return errorExitCode
+	}
+	var auth *VaultAuth
+	if err == nil {
+		mount := globalOpts.vaultMount
+		if mount == "" {
+			mount = method
+		}
+		if auth, err = vaultLogin(ctx, globalOpts.vaultAddr, mount, login); err == nil {
+			err = writeVaultAuth(auth)
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to log in to Vault: %s\n", err)
+		status.Error = err.Error()
+		return keyErrorExitCode(err)
+	}
+	return foundExitCode
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+// The iam method is given a signed sts:GetCallerIdentity request, which
+// Vault sends on to learn the role.  The global STS endpoint is the one
+// Vault uses by default; other partitions have only regional ones.
+func (c *AWSCloud) vaultIAMLogin(ctx context.Context, role string, serverId string) (map[string]interface{}, error) {
+	creds, err := c.roleCredentials(ctx)
+	if err != nil {
+		return nil, err
+	}
+	region, domain := "us-east-1", c.attributes["aws.domain"]
+	stsUrl := "https://sts.amazonaws.com/"
+	if domain != "amazonaws.com" {
+		region = c.attributes["aws.region"]
+		stsUrl = "https://sts." + region + "." + domain + "/"
+	}
+	body := "Action=GetCallerIdentity&Version=2011-06-15"
+	headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"}
+	if serverId != "" {
+		headers["X-Vault-AWS-IAM-Server-ID"] = serverId
+	}
+	signed, err := signAWSRequest(creds, "sts", region, "POST", stsUrl, headers, body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	encodedHeaders, err := json.Marshal(signed)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{
+		"role":                    role,
+		"iam_http_request_method": "POST",
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(stsUrl)),
+		"iam_request_body":        base64.StdEncoding.EncodeToString([]byte(body)),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(encodedHeaders),
+	}, nil
+}
+
+// The ec2 method is given the PKCS7 signature of the identity document.
+// Vault hands out a nonce on the first login which later ones must repeat,
+// unless the role allows reauthentication.
+func (c *AWSCloud) vaultEC2Login(ctx context.Context, role string, nonce string) (map[string]interface{}, error) {
+	doc, err := c.identityDocument(ctx)
+	if err != nil {
+		return nil, err
+	}
+	login := map[string]interface{}{
+		"role":  role,
+		"pkcs7": strings.Replace(doc.Signature, "\n", "", -1),
+	}
+	if nonce != "" {
+		login["nonce"] = nonce
+	}
+	return login, nil
+}
+
+/////////////////////////////////////////////////////////
+// GCE
+/////////////////////////////////////////////////////////
+// The gcp method takes an identity token minted for vault/<role>.
+func (c *GCECloud) vaultLogin(ctx context.Context, role string) (map[string]interface{}, error) {
+	audience := globalOpts.audience
+	if audience == "" {
+		audience = "http://vault/" + role
+	}
+	token, err := c.getKey(ctx, "instance/service-accounts/default/identity?format=full&audience="+url.QueryEscape(audience))
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"role": role, "jwt": strings.TrimSpace(*token)}, nil
+}
+
+/////////////////////////////////////////////////////////
+// Azure
+/////////////////////////////////////////////////////////
+// The azure method takes a managed identity token for the resource Vault
+// was configured with, -resource, and the VM or scale set it is from.
+func (c *AzureCloud) vaultLogin(ctx context.Context, role string) (map[string]interface{}, error) {
+	token, err := c.accessToken(ctx, globalOpts.resource, globalOpts.clientId)
+	if err != nil {
+		return nil, err
+	}
+	values := fetchFields(ctx, c, []summaryField{
+		{"subscription_id", "compute/subscriptionId", nil},
+		{"resource_group_name", "compute/resourceGroupName", nil},
+		{"vm_name", "compute/name", nil},
+		{"vmss_name", "compute/vmScaleSetName", nil},
+	})
+	login := map[string]interface{}{
+		"role":                role,
+		"jwt":                 token.AccessToken,
+		"subscription_id":     values["subscription_id"],
+		"resource_group_name": values["resource_group_name"],
+	}
+	if values["vmss_name"] != "" {
+		login["vmss_name"] = values["vmss_name"]
+	} else {
+		login["vm_name"] = values["vm_name"]
+	}
+	return login, nil
+}
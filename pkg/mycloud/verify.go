@@ -0,0 +1,463 @@
+package mycloud
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	_ "embed"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The certificates AWS signs identity documents with, one per partition or
+// region.  The built in ones can be added to with PEM files in
+// awsCertificatesDir, as AWS publishes them, without a new binary.
+//
+//go:embed aws-certificates.pem
+var embeddedAWSCertificates []byte
+
+const awsCertificatesDir = "/etc/mycloud/aws-certificates.d"
+
+func loadAWSCertificates(dir string) []*x509.Certificate {
+	bundles := [][]byte{embeddedAWSCertificates}
+	paths, _ := filepath.Glob(filepath.Join(dir, "*.pem"))
+	sort.Strings(paths)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			logOutput("Ignoring the certificate file %s: %s\n", path, err)
+			continue
+		}
+		bundles = append(bundles, data)
+	}
+	var certs []*x509.Certificate
+	for _, data := range bundles {
+		for {
+			var block *pem.Block
+			block, data = pem.Decode(data)
+			if block == nil {
+				break
+			}
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				logOutput("Ignoring an invalid AWS certificate: %s\n", err)
+				continue
+			}
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// Signatures that are valid for none of the keys mean the document, or the
+// metadata service that served it, is not the cloud's.
+type VerificationError struct {
+	reason string
+}
+
+func (e *VerificationError) Error() string {
+	return "The identity document could not be verified: " + e.reason
+}
+
+// Clouds that were rejected because their identity could not be verified.
+type Verifier interface {
+	verificationFailed() bool
+}
+
+/////////////////////////////////////////////////////////
+// PKCS7
+/////////////////////////////////////////////////////////
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue     `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue     `asn1:"optional,tag:1"`
+	SignerInfos      []pkcs7SignerInfo `asn1:"set"`
+}
+
+type pkcs7SignerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     asn1.RawValue
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type pkcs7Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"set"`
+}
+
+var (
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSHA1          = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// AWS encodes its signatures in BER, with indefinite lengths and the
+// content split into chunks, which encoding/asn1 does not read.  Lengths
+// are made definite and chunked octet strings are joined.
+func berToDer(ber []byte) ([]byte, []byte, error) {
+	if len(ber) < 2 {
+		return nil, nil, errors.New("Truncated BER")
+	}
+	tag := ber[0]
+	if tag&0x1f == 0x1f {
+		return nil, nil, errors.New("Long BER tags are not supported")
+	}
+	length, rest := int(ber[1]), ber[2:]
+	indefinite := length == 0x80
+	if length > 0x80 {
+		n := length & 0x7f
+		if n > 4 || len(rest) < n {
+			return nil, nil, errors.New("Invalid BER length")
+		}
+		length = 0
+		for _, b := range rest[:n] {
+			length = length<<8 | int(b)
+		}
+		rest = rest[n:]
+	}
+	if !indefinite && len(rest) < length {
+		return nil, nil, errors.New("Truncated BER")
+	}
+	var body []byte
+	if tag&0x20 == 0 {
+		if indefinite {
+			return nil, nil, errors.New("Primitive BER with an indefinite length")
+		}
+		body, rest = rest[:length], rest[length:]
+	} else {
+		inner := rest
+		if !indefinite {
+			inner, rest = rest[:length], rest[length:]
+		}
+		var children [][]byte
+		for {
+			if indefinite && len(inner) >= 2 && inner[0] == 0 && inner[1] == 0 {
+				rest = inner[2:]
+				break
+			}
+			if !indefinite && len(inner) == 0 {
+				break
+			}
+			child, remaining, err := berToDer(inner)
+			if err != nil {
+				return nil, nil, err
+			}
+			children = append(children, child)
+			inner = remaining
+		}
+		if tag == 0x24 {
+			// A constructed octet string is the chunks of a primitive one.
+			tag = 0x04
+			for _, child := range children {
+				_, content, err := derContent(child)
+				if err != nil {
+					return nil, nil, err
+				}
+				body = append(body, content...)
+			}
+		} else {
+			body = bytes.Join(children, nil)
+		}
+	}
+	return append(derHeader(tag, len(body)), body...), rest, nil
+}
+
+func derHeader(tag byte, length int) []byte {
+	if length < 0x80 {
+		return []byte{tag, byte(length)}
+	}
+	var n []byte
+	for l := length; l > 0; l >>= 8 {
+		n = append([]byte{byte(l)}, n...)
+	}
+	return append([]byte{tag, 0x80 | byte(len(n))}, n...)
+}
+
+func derContent(der []byte) (byte, []byte, error) {
+	var v asn1.RawValue
+	if _, err := asn1.Unmarshal(der, &v); err != nil {
+		return 0, nil, err
+	}
+	return der[0], v.Bytes, nil
+}
+
+func digestFor(algorithm asn1.ObjectIdentifier, data []byte) (crypto.Hash, []byte, error) {
+	switch {
+	case algorithm.Equal(oidSHA256):
+		sum := sha256.Sum256(data)
+		return crypto.SHA256, sum[:], nil
+	case algorithm.Equal(oidSHA1):
+		sum := sha1.Sum(data)
+		return crypto.SHA1, sum[:], nil
+	}
+	return 0, nil, errors.New("Unsupported digest algorithm " + algorithm.String())
+}
+
+func parsePKCS7(signature []byte) (*pkcs7SignedData, error) {
+	der, _, err := berToDer(signature)
+	if err != nil {
+		return nil, err
+	}
+	var info pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.ContentType.Equal(oidSignedData) {
+		return nil, errors.New("The signature is not PKCS7 signed data")
+	}
+	var signed pkcs7SignedData
+	if _, err := asn1.Unmarshal(info.Content.Bytes, &signed); err != nil {
+		return nil, err
+	}
+	return &signed, nil
+}
+
+// The document a PKCS7 signature carries, nil when it is detached.
+func (signed *pkcs7SignedData) content() ([]byte, error) {
+	if len(signed.ContentInfo.Content.Bytes) == 0 {
+		return nil, nil
+	}
+	var content []byte
+	if _, err := asn1.Unmarshal(signed.ContentInfo.Content.Bytes, &content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// The document inside a PKCS7 signature, as Azure's attested document has
+// it, without checking the signature.
+func pkcs7Content(signature []byte) ([]byte, error) {
+	signed, err := parsePKCS7(signature)
+	if err != nil {
+		return nil, err
+	}
+	content, err := signed.content()
+	if err == nil && content == nil {
+		err = errors.New("The signature carries no document")
+	}
+	return content, err
+}
+
+// Check a PKCS7 signature of the document against the certificates.  The
+// signature carries the document too, which must be the one that was served.
+func verifyPKCS7(signature []byte, document []byte, certs []*x509.Certificate) error {
+	signed, err := parsePKCS7(signature)
+	if err != nil {
+		return err
+	}
+	content, err := signed.content()
+	if err != nil {
+		return err
+	}
+	if content == nil {
+		content = document
+	} else if !bytes.Equal(bytes.TrimSpace(content), bytes.TrimSpace(document)) {
+		return errors.New("The signed document is not the one that was served")
+	}
+	if len(signed.SignerInfos) == 0 {
+		return errors.New("The signature has no signers")
+	}
+	signer := signed.SignerInfos[0]
+	hash, digest, err := digestFor(signer.DigestAlgorithm.Algorithm, content)
+	if err != nil {
+		return err
+	}
+	// With authenticated attributes it is them that are signed, and they
+	// hold the digest of the content.
+	if len(signer.AuthenticatedAttributes.Bytes) > 0 {
+		if err := checkMessageDigest(signer.AuthenticatedAttributes.Bytes, digest); err != nil {
+			return err
+		}
+		attributes := append([]byte{0x31}, signer.AuthenticatedAttributes.FullBytes[1:]...)
+		if _, digest, err = digestFor(signer.DigestAlgorithm.Algorithm, attributes); err != nil {
+			return err
+		}
+	}
+	for _, cert := range certs {
+		key, ok := cert.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			continue
+		}
+		if rsa.VerifyPKCS1v15(key, hash, digest, signer.EncryptedDigest) == nil {
+			return nil
+		}
+	}
+	return errors.New("The signature is valid for none of the " + strconv.Itoa(len(certs)) + " AWS certificates")
+}
+
+func checkMessageDigest(attributes []byte, digest []byte) error {
+	for len(attributes) > 0 {
+		var attribute pkcs7Attribute
+		var err error
+		if attributes, err = asn1.Unmarshal(attributes, &attribute); err != nil {
+			return err
+		}
+		if !attribute.Type.Equal(oidMessageDigest) {
+			continue
+		}
+		var value []byte
+		if _, err := asn1.Unmarshal(attribute.Value.Bytes, &value); err != nil {
+			return err
+		}
+		if !bytes.Equal(value, digest) {
+			return errors.New("The digest of the document does not match the signed one")
+		}
+		return nil
+	}
+	return errors.New("The signature has no message digest")
+}
+
+/////////////////////////////////////////////////////////
+// AWS
+/////////////////////////////////////////////////////////
+// The RSA-2048 PKCS7 signature is served beside the document, base64
+// encoded without PEM armour.
+func (c *AWSCloud) verifyIdentity(ctx context.Context, document string) error {
+	certs := loadAWSCertificates(awsCertificatesDir)
+	if len(certs) == 0 {
+		return &VerificationError{"there are no AWS certificates in " + awsCertificatesDir}
+	}
+	signatureUrl := strings.TrimSuffix(c.fingerprint.Urls["identity"], "document") + "rsa2048"
+	encoded, _, err := getUrl(ctx, signatureUrl, c.headers)
+	if err != nil {
+		return &VerificationError{err.Error()}
+	}
+	signature, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(*encoded), ""))
+	if err != nil {
+		return &VerificationError{"the signature is not base64: " + err.Error()}
+	}
+	if err := verifyPKCS7(signature, []byte(document), certs); err != nil {
+		return &VerificationError{err.Error()}
+	}
+	return nil
+}
+
+func (c *AWSCloud) verificationFailed() bool {
+	_, ok := c.probeError.(*VerificationError)
+	return ok
+}
+
+/////////////////////////////////////////////////////////
+// JWT
+/////////////////////////////////////////////////////////
+var googleIssuers = []string{"https://accounts.google.com", "accounts.google.com"}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func (k jsonWebKey) publicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.N, "="))
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(k.E, "="))
+	if err != nil {
+		return nil, err
+	}
+	exponent := 0
+	for _, b := range e {
+		exponent = exponent<<8 | int(b)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+}
+
+// Check a Google signed RS256 token against the keys of the JWKS, and that
+// it was issued by Google for the audience and has not expired.
+func verifyJWT(jwt string, jwks []byte, audience string, now time.Time) (map[string]interface{}, error) {
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("The identity token is not a JWT")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "RS256" {
+		return nil, errors.New("Unsupported token algorithm " + header.Alg)
+	}
+	var keys struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(jwks, &keys); err != nil {
+		return nil, err
+	}
+	var key *rsa.PublicKey
+	for _, k := range keys.Keys {
+		if k.Kid == header.Kid && k.Kty == "RSA" {
+			var err error
+			if key, err = k.publicKey(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if key == nil {
+		return nil, errors.New("No key " + header.Kid + " in the JWKS")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[2], "="))
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, errors.New("The token's signature is not valid")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if aud, _ := claims["aud"].(string); aud != audience {
+		return nil, errors.New("The token is for the audience " + aud + ", not " + audience)
+	}
+	iss, _ := claims["iss"].(string)
+	if iss != googleIssuers[0] && iss != googleIssuers[1] {
+		return nil, errors.New("The token was issued by " + iss + ", not Google")
+	}
+	exp, _ := claims["exp"].(float64)
+	if now.Unix() >= int64(exp) {
+		return nil, errors.New("The token has expired")
+	}
+	return claims, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(part, "="))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,145 @@
+package mycloud
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The certificates AWS signs with, plus those of one testdata file.
+func certificatesWith(t *testing.T, name string) string {
+	dir := t.TempDir()
+	if name != "" {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), readTestdata(t, name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// The signature is openssl's streamed, indefinite length BER PKCS7, as AWS
+// serves it, made by a test certificate in place of AWS's own.
+func TestVerifyPKCS7(t *testing.T) {
+	document := readTestdata(t, "aws-document.json")
+	signature, err := base64.StdEncoding.DecodeString(string(bytes.Join(bytes.Fields(readTestdata(t, "aws-rsa2048")), nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := bytes.Replace(document, []byte("123456789012"), []byte("210987654321"), 1)
+
+	tests := []struct {
+		name      string
+		document  []byte
+		signature []byte
+		certs     string
+		valid     bool
+	}{
+		{"valid", document, signature, "aws-signer.pem", true},
+		{"tampered document", tampered, signature, "aws-signer.pem", false},
+		{"wrong certificate", document, signature, "other.pem", false},
+		{"AWS certificates only", document, signature, "", false},
+		{"truncated signature", document, signature[:len(signature)-40], "aws-signer.pem", false},
+	}
+	for _, test := range tests {
+		certs := loadAWSCertificates(certificatesWith(t, test.certs))
+		err := verifyPKCS7(test.signature, test.document, certs)
+		if test.valid && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: verified", test.name)
+		}
+	}
+}
+
+func TestLoadAWSCertificates(t *testing.T) {
+	builtin := len(loadAWSCertificates(certificatesWith(t, "")))
+	dir := certificatesWith(t, "aws-signer.pem")
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.pem"), []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(loadAWSCertificates(dir)); got != builtin+1 {
+		t.Errorf("got %d certificates, want %d", got, builtin+1)
+	}
+	if got := len(loadAWSCertificates(filepath.Join(dir, "missing"))); got != builtin {
+		t.Errorf("a missing directory gave %d certificates, want %d", got, builtin)
+	}
+}
+
+func signJWT(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []jsonWebKey{{
+		Kid: "key1",
+		Kty: "RSA",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}})
+	now := time.Unix(1700000000, 0)
+	const audience = "https://vault.example.com"
+	claims := func(change func(map[string]interface{})) map[string]interface{} {
+		c := map[string]interface{}{
+			"aud": audience,
+			"iss": "https://accounts.google.com",
+			"iat": now.Unix() - 60,
+			"exp": now.Unix() + 3600,
+			"sub": "112233445566778899000",
+		}
+		if change != nil {
+			change(c)
+		}
+		return c
+	}
+	valid := signJWT(t, key, "key1", claims(nil))
+	parts := strings.Split(valid, ".")
+	forged := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"aud":"`+audience+`","iss":"accounts.google.com","exp":9999999999}`)) + "." + parts[2]
+
+	tests := []struct {
+		name  string
+		jwt   string
+		valid bool
+	}{
+		{"valid", valid, true},
+		{"short issuer", signJWT(t, key, "key1", claims(func(c map[string]interface{}) { c["iss"] = "accounts.google.com" })), true},
+		{"expired", signJWT(t, key, "key1", claims(func(c map[string]interface{}) { c["exp"] = now.Unix() })), false},
+		{"wrong audience", signJWT(t, key, "key1", claims(func(c map[string]interface{}) { c["aud"] = "https://other.example.com" })), false},
+		{"wrong issuer", signJWT(t, key, "key1", claims(func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" })), false},
+		{"kid not in the JWKS", signJWT(t, key, "key2", claims(nil)), false},
+		{"forged claims", forged, false},
+		{"not a JWT", "a.b", false},
+	}
+	for _, test := range tests {
+		_, err := verifyJWT(test.jwt, jwks, audience, now)
+		if test.valid && err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if !test.valid && err == nil {
+			t.Errorf("%s: verified", test.name)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package mycloud
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// VirtualBox and Vagrant
+/////////////////////////////////////////////////////////
+type VirtualBoxDetector struct {
+	BaseCloud
+	vboxControl string
+}
+
+// Developer machines rather than a cloud.  They are reported with
+// development=true so that CI scripts can tell them from the real thing.
+func NewVirtualBoxDetector() VirtualBoxDetector {
+	c := VirtualBoxDetector{}
+	c.fingerprint = fingerprintFor("virtualbox")
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceLow
+	return c
+}
+
+// Vagrant boxes all have the vagrant user, and the project directory is
+// shared at /vagrant unless the Vagrantfile turns that off.
+func isVagrant() bool {
+	if _, err := user.Lookup("vagrant"); err == nil {
+		return true
+	}
+	_, err := os.Stat("/vagrant")
+	return err == nil
+}
+
+func (c *VirtualBoxDetector) detectEffectiveCloud(ctx context.Context) {
+	c.isMyCloud = dmiMatches(readDMI(), c.fingerprint.DMI)
+	c.signal = signalDMI
+	if !c.isMyCloud {
+		return
+	}
+	c.setAttribute("development", "true")
+	if isVagrant() {
+		c.name = fingerprintFor("vagrant").Name
+	}
+	for _, path := range c.fingerprint.Files {
+		if _, err := os.Stat(path); err == nil {
+			c.vboxControl = path
+			c.supportsKey = true
+			break
+		}
+	}
+}
+
+// Keys are guest properties, read with VBoxControl from the guest
+// additions, e.g. /VirtualBox/GuestInfo/OS/Product.
+func (c *VirtualBoxDetector) getKey(ctx context.Context, key string) (*string, error) {
+	if c.vboxControl == "" {
+		return nil, errors.New("The guest additions are not installed")
+	}
+	out, err := exec.Command(c.vboxControl, "--nologo", "guestproperty", "get", key).Output()
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSpace(string(out))
+	if !strings.HasPrefix(s, "Value: ") {
+		return nil, errors.New("No such key " + key)
+	}
+	s = strings.TrimPrefix(s, "Value: ")
+	return &s, nil
+}
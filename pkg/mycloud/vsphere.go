@@ -0,0 +1,70 @@
+package mycloud
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// VMware vSphere
+/////////////////////////////////////////////////////////
+type VSphereCloud struct {
+	BaseCloud
+	rpcTool string
+}
+
+// There is no metadata server, the guestinfo variables set on the VM are
+// read over the backdoor with the tools from open-vm-tools.
+func NewVSphereCloud() VSphereCloud {
+	c := VSphereCloud{}
+	c.fingerprint = fingerprintFor("vsphere")
+	c.supportsKey = true
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceLow
+	return c
+}
+
+// The tools are often installed in images that also run elsewhere, so the
+// virtual hardware has to be VMware's as well when it can be read.
+func (c *VSphereCloud) detectEffectiveCloud(ctx context.Context) {
+	c.isMyCloud = false
+	for _, path := range c.fingerprint.Files {
+		if _, err := os.Stat(path); err == nil {
+			c.rpcTool = path
+			break
+		}
+	}
+	if c.rpcTool == "" {
+		return
+	}
+	if dmi := readDMI(); len(dmi) > 0 && !dmiMatches(dmi, c.fingerprint.DMI) {
+		return
+	}
+	c.isMyCloud = true
+	c.signal = signalFiles
+}
+
+// Keys are guestinfo variables with or without the guestinfo. prefix.
+func (c *VSphereCloud) getKey(ctx context.Context, key string) (*string, error) {
+	if !strings.HasPrefix(key, "guestinfo.") {
+		key = "guestinfo." + key
+	}
+	command := "info-get " + key
+	var cmd *exec.Cmd
+	if filepath.Base(c.rpcTool) == "vmtoolsd" {
+		cmd = exec.Command(c.rpcTool, "--cmd", command)
+	} else {
+		cmd = exec.Command(c.rpcTool, command)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	s := string(out)
+	return &s, nil
+}
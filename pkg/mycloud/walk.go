@@ -0,0 +1,215 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How a tree without a bulk endpoint, such as AWS's, is walked with a
+// request per path.  The metadata services throttle an instance that asks
+// too fast, and some trees are large enough to walk for minutes.
+type walkPolicy struct {
+	// Requests a second, 0 for as fast as they are answered.
+	rate        float64
+	concurrency int
+	// Requests a walk may make, 0 for no limit.  Keys read back from the
+	// resume file do not count.
+	maxKeys int
+	// A file the walk records the keys it has fetched in, and reads them
+	// back from, so that a walk that failed or hit maxKeys can be run
+	// again to carry on where it stopped.
+	resume string
+}
+
+var defaultWalkPolicy = walkPolicy{rate: 50, concurrency: 4, maxKeys: 5000}
+
+// The resume file is written after this many new keys, as well as when
+// the walk stops.
+const walkCheckpoint = 100
+
+// A walk stopped at maxKeys.
+type WalkLimitError struct {
+	maxKeys int
+}
+
+func (e *WalkLimitError) Error() string {
+	return fmt.Sprintf("The tree has more than the %d keys a walk may fetch", e.maxKeys)
+}
+
+type treeWalker struct {
+	cd      CloudDetector
+	skipped []string
+	policy  walkPolicy
+	slots   chan struct{}
+
+	lock    sync.Mutex
+	next    time.Time
+	fetched int
+	// Every key fetched, this run or one before it, by path.  The root
+	// listing is "".
+	keys    map[string]string
+	unsaved int
+}
+
+func newTreeWalker(ctx context.Context, cd CloudDetector, skipped []string) *treeWalker {
+	policy := settingsOf(ctx).walking()
+	w := &treeWalker{cd: cd, skipped: skipped, policy: policy, slots: make(chan struct{}, policy.concurrency), keys: map[string]string{}}
+	if policy.resume != "" {
+		if data, err := ioutil.ReadFile(policy.resume); err == nil {
+			if err := json.Unmarshal(data, &w.keys); err != nil {
+				logOutput("Ignoring the resume file %s: %s\n", policy.resume, err)
+				w.keys = map[string]string{}
+			} else {
+				logOutput("Resuming with the %d keys in %s\n", len(w.keys), policy.resume)
+			}
+		}
+	}
+	return w
+}
+
+// Walk an EC2 style tree, where a directory lists its entries one per line
+// and subdirectories end with a /.  The public keys are listed as
+// <index>=<name> and are directories too.  Keys under the skipped prefixes
+// are left out.
+func walkTree(ctx context.Context, cd CloudDetector, dir string, skipped []string) (map[string]interface{}, error) {
+	w := newTreeWalker(ctx, cd, skipped)
+	tree, err := w.walk(ctx, dir)
+	if w.policy.resume == "" {
+		return tree, err
+	}
+	if err != nil {
+		if saveErr := w.save(); saveErr != nil {
+			logOutput("Could not write the resume file %s: %s\n", w.policy.resume, saveErr)
+		}
+		return nil, err
+	}
+	os.Remove(w.policy.resume)
+	return tree, nil
+}
+
+func (w *treeWalker) walk(ctx context.Context, dir string) (map[string]interface{}, error) {
+	listing, err := w.get(ctx, dir)
+	if err != nil {
+		return nil, err
+	}
+	tree := map[string]interface{}{}
+	var treeLock sync.Mutex
+	var stopped error
+	var wg sync.WaitGroup
+	for _, line := range strings.Split(listing, "\n") {
+		name := strings.TrimSpace(line)
+		if name == "" {
+			continue
+		}
+		if i := strings.Index(name, "="); i > 0 {
+			name = name[:i] + "/"
+		}
+		key := dir + name
+		if hasAnyPrefix(key, w.skipped) {
+			continue
+		}
+		wg.Add(1)
+		go func(name string, key string) {
+			defer wg.Done()
+			var val interface{}
+			var err error
+			if strings.HasSuffix(name, "/") {
+				name = strings.TrimSuffix(name, "/")
+				val, err = w.walk(ctx, key)
+				if err != nil && !stopsWalk(ctx, err) {
+					logOutput("Could not walk %s: %s\n", key, err)
+				}
+			} else {
+				val, err = w.get(ctx, key)
+				if err != nil && !stopsWalk(ctx, err) {
+					logOutput("Could not get %s: %s\n", key, err)
+				}
+			}
+			treeLock.Lock()
+			defer treeLock.Unlock()
+			if err == nil {
+				tree[name] = val
+			} else if stopsWalk(ctx, err) && stopped == nil {
+				stopped = err
+			}
+		}(name, key)
+	}
+	wg.Wait()
+	if stopped != nil {
+		return nil, stopped
+	}
+	return tree, nil
+}
+
+// Errors that end the whole walk rather than leave out one key.
+func stopsWalk(ctx context.Context, err error) bool {
+	var limit *WalkLimitError
+	return errors.As(err, &limit) || ctx.Err() != nil
+}
+
+func (w *treeWalker) get(ctx context.Context, key string) (string, error) {
+	w.lock.Lock()
+	if val, ok := w.keys[key]; ok {
+		w.lock.Unlock()
+		return val, nil
+	}
+	if w.policy.maxKeys > 0 && w.fetched >= w.policy.maxKeys {
+		w.lock.Unlock()
+		return "", &WalkLimitError{w.policy.maxKeys}
+	}
+	w.fetched++
+	// Each request is given the next free time of the rate.
+	var wait time.Duration
+	if w.policy.rate > 0 {
+		now := time.Now()
+		if w.next.Before(now) {
+			w.next = now
+		}
+		wait = w.next.Sub(now)
+		w.next = w.next.Add(time.Duration(float64(time.Second) / w.policy.rate))
+	}
+	w.lock.Unlock()
+
+	if err := sleepContext(ctx, wait); err != nil {
+		return "", err
+	}
+	w.slots <- struct{}{}
+	val, err := w.cd.getKey(ctx, key)
+	<-w.slots
+	if err != nil {
+		return "", err
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.keys[key] = *val
+	w.unsaved++
+	if w.policy.resume != "" && w.unsaved >= walkCheckpoint {
+		if err := w.saveLocked(); err != nil {
+			logOutput("Could not write the resume file %s: %s\n", w.policy.resume, err)
+		}
+	}
+	return *val, nil
+}
+
+func (w *treeWalker) save() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.saveLocked()
+}
+
+func (w *treeWalker) saveLocked() error {
+	out, err := json.Marshal(w.keys)
+	if err != nil {
+		return err
+	}
+	w.unsaved = 0
+	return writeFileAtomic(w.policy.resume, out, 0600)
+}
@@ -0,0 +1,159 @@
+package mycloud
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// On AWS the auto scaling lifecycle state is what is usually worth watching:
+// it moves to Warmed:Pending, InService or Terminated as the group acts on
+// the instance.
+const awsLifecycleKey = "autoscaling/target-lifecycle-state"
+
+// Run the -exec hook with the change in its environment.  The hook is run
+// through the shell so that it can be given arguments.
+func runHook(hook string, key string, previous string, value string) {
+	cmd := exec.Command("/bin/sh", "-c", hook)
+	cmd.Env = append(os.Environ(),
+		"MYCLOUD_KEY="+key,
+		"MYCLOUD_PREVIOUS_VALUE="+previous,
+		"MYCLOUD_VALUE="+value)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "The hook %s failed: %s\n", hook, err)
+	}
+}
+
+// With -format json the watch prints one JSON event per line, so log
+// shippers can take the stream as it is.  The event is detection, change
+// or error.
+type watchEvent struct {
+	Time       string            `json:"time"`
+	Event      string            `json:"event"`
+	Cloud      string            `json:"cloud,omitempty"`
+	Detected   *bool             `json:"detected,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+	Key        string            `json:"key,omitempty"`
+	Value      *string           `json:"value,omitempty"`
+	Previous   *string           `json:"previous,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+func emitEvent(event watchEvent) {
+	event.Time = time.Now().UTC().Format(time.RFC3339Nano)
+	out, err := json.Marshal(event)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to write the event: %s\n", err)
+		return
+	}
+	fmt.Printf("%s\n", out)
+}
+
+func emitDetection(cd CloudDetector) {
+	detected := cd != nil
+	event := watchEvent{Event: "detection", Cloud: "UNKNOWN", Detected: &detected}
+	if cd != nil {
+		event.Cloud = cd.cloudDescription()
+		event.Attributes = cd.cloudAttributes()
+	}
+	emitEvent(event)
+}
+
+// Whether the error is the metadata service saying the key is not there.
+// Being turned away, with a 401 or 403, is not.
+func keyAbsent(err error) bool {
+	if classifyError(err) != ErrorCategoryHTTPStatus {
+		return false
+	}
+	code := statusCodeOf(err)
+	return code != http.StatusUnauthorized && code != http.StatusForbidden
+}
+
+// Poll a key and report every change of its value until killed.  A key that
+// does not exist (yet) is treated as an empty value, so the hook also runs
+// when the key appears or goes away.
+func runWatch(ctx context.Context, cdList []CloudDetector, status *RunStatus) int {
+	events := globalOpts.format == "json"
+	s := *settingsOf(ctx)
+	breaker := NewProbeBreaker(globalOpts.breakerFailures, globalOpts.breakerCooldown)
+	s.breaker = &breaker
+	ctx = withSettings(ctx, &s)
+	cd := detect(ctx, cdList, status)
+	if events {
+		emitDetection(cd)
+	}
+	// With -wait-for-cloud the clouds are probed again every interval,
+	// e.g. while the metadata service comes up at boot, bar those the
+	// breaker has given up on for a while.
+	for cd == nil && globalOpts.waitForCloud {
+		logOutput("No cloud found, detecting again in %s\n", globalOpts.interval)
+		if sleepContext(ctx, globalOpts.interval) != nil {
+			return detectionFailedExitCode(cdList)
+		}
+		cdList = setupClouds()
+		status.Probes = nil
+		if cd = detect(ctx, cdList, status); cd != nil && events {
+			emitDetection(cd)
+		}
+	}
+	if cd == nil {
+		if !events {
+			fmt.Printf("UNKNOWN\n")
+		}
+		return detectionFailedExitCode(cdList)
+	}
+	key := globalOpts.key
+	if key == "" && cd.cloudFingerprint().ID == "aws" {
+		key = awsLifecycleKey
+	}
+	if key == "" {
+		fmt.Fprintf(os.Stderr, "A key to watch must be given with -key\n")
+		return usageExitCode
+	}
+	logOutput("Watching the key %s every %s\n", key, globalOpts.interval)
+
+	first := true
+	previous := ""
+	for {
+		value := ""
+		val, err := cd.getKey(ctx, key)
+		if err == nil {
+			value = strings.TrimSpace(*val)
+		} else if !keyAbsent(err) {
+			logOutput("Failed to get the key %s.  Error: %s\n", key, err)
+			if events {
+				emitEvent(watchEvent{Event: "error", Key: key, Error: err.Error()})
+			}
+			if sleepContext(ctx, globalOpts.interval) != nil {
+				return foundExitCode
+			}
+			continue
+		}
+		if first || value != previous {
+			if events {
+				event := watchEvent{Event: "change", Key: key, Value: &value}
+				if !first {
+					event.Previous = &previous
+				}
+				emitEvent(event)
+			} else {
+				fmt.Printf("%s\n", value)
+			}
+			if !first && globalOpts.hook != "" {
+				runHook(globalOpts.hook, key, previous, value)
+			}
+			previous = value
+			first = false
+		}
+		if sleepContext(ctx, globalOpts.interval) != nil {
+			return foundExitCode
+		}
+	}
+}
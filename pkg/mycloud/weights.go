@@ -0,0 +1,42 @@
+package mycloud
+
+// What a match rests on.  Sites where one of them cannot be trusted, such
+// as a metadata proxy that answers like a cloud it is not in, or images
+// cloned with a seed drive left on them, can weigh it down, or another up,
+// with the weights of a fingerprint file:
+//
+//	{"weights": {"http": -50, "dmi": 40}}
+const (
+	// The cloud's metadata service answered, at its usual address.
+	signalHTTP = "http"
+	// It answered at a name the DNS resolves, CloudStack's data-server.
+	signalDNS = "dns"
+	// It answered at the DHCP server of a lease, CloudStack's router.
+	signalDHCP = "dhcp"
+	signalDMI  = "dmi"
+	// Seed and config drives, agents' files and devices.
+	signalFiles       = "files"
+	signalEnvironment = "environment"
+	// The CPUID hypervisor signature.  A cloud it disagrees with loses
+	// cpuidDisagreement whatever this weight is.
+	signalCPUID = "cpuid"
+)
+
+var knownSignals = map[string]bool{
+	signalHTTP:        true,
+	signalDNS:         true,
+	signalDHCP:        true,
+	signalDMI:         true,
+	signalFiles:       true,
+	signalEnvironment: true,
+	signalCPUID:       true,
+}
+
+// What the signal adds to the confidence of a match, 0 unless a
+// fingerprint file says otherwise.
+func signalWeight(signal string) int {
+	if signal == "" {
+		signal = signalHTTP
+	}
+	return loadedFingerprints().Weights[signal]
+}
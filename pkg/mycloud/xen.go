@@ -0,0 +1,64 @@
+package mycloud
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+
+	"github.com/buzztroll/mycloud/pkg/mycloud/provider"
+)
+
+/////////////////////////////////////////////////////////
+// Xen
+/////////////////////////////////////////////////////////
+type XenDetector struct {
+	BaseCloud
+}
+
+const sysHypervisorDir = "/sys/hypervisor/"
+
+func NewXenDetector() XenDetector {
+	c := XenDetector{}
+	c.fingerprint = fingerprintFor("xen")
+	c.name = c.fingerprint.Name
+	c.confidence = provider.ConfidenceLow
+	return c
+}
+
+func readSysHypervisor(name string) string {
+	data, err := ioutil.ReadFile(sysHypervisorDir + name)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// Paravirtualized guests have no DMI data at all, but the kernel still
+// says xen in /sys/hypervisor/type.  The instances AWS ran on Xen have a
+// domain UUID starting with ec2 and Amazon in their BIOS version, which is
+// how they are told from XenServer and XCP-ng guests when the metadata
+// service cannot be reached.
+func (c *XenDetector) detectEffectiveCloud(ctx context.Context) {
+	dmi := readDMI()
+	switch {
+	case readSysHypervisor("type") == "xen":
+		c.signal = signalFiles
+	case cpuidHypervisor() == "Xen":
+		c.signal = signalCPUID
+	case dmiHypervisor(dmi) == "Xen":
+		c.signal = signalDMI
+	default:
+		c.isMyCloud = false
+		return
+	}
+	c.isMyCloud = true
+	uuid := strings.ToLower(readSysHypervisor("uuid"))
+	if strings.HasPrefix(uuid, "ec2") || dmiMatches(dmi, fingerprintFor("aws").DMI) {
+		c.setAttribute("xen.platform", "aws")
+	} else {
+		c.setAttribute("xen.platform", "xenserver")
+	}
+	if major, minor := readSysHypervisor("version/major"), readSysHypervisor("version/minor"); major != "" {
+		c.setAttribute("xen.version", major+"."+minor)
+	}
+}